@@ -0,0 +1,358 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/addons"
+)
+
+type command struct {
+	name string
+	args string
+	help string
+	run  func(conf addons.Conf, args []string) error
+	// commands that only look at AddOns can run without a config file
+	needsConfig bool
+}
+
+var commands = []command{
+	{name: "install", help: "install and reconcile every addon in the config (default)", run: install, needsConfig: true},
+	{name: "update", args: "[name...]", help: "update only the named addons, or all", run: update, needsConfig: true},
+	{name: "remove", args: "name...", help: "remove installed addons by folder or entry name", run: remove},
+	{name: "list", help: "list installed addons", run: list},
+	{name: "status", help: "compare installed addons with the config and their sources", run: status, needsConfig: true},
+	{name: "plan", args: "[-out file] [-json]", help: "print what install would change", run: plan, needsConfig: true},
+	{name: "apply", args: "[file]", help: "apply a plan file, or plan and apply the config", run: apply, needsConfig: true},
+	{name: "restore", args: "[snapshot [name...]]", help: "list backup snapshots, or restore addons from one", run: restore},
+	{name: "search", args: "term", help: "search the config's catalogs by name, author or TOC title", run: search},
+	{name: "cache", args: "ls | gc [-maxsize size]", help: "list the download cache, or evict it down to a size", run: cache},
+}
+
+// dryRun is the global -dry-run flag, commands that change AddOns only print their plan
+var dryRun bool
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+
+	return nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [global options] <command> [args]\n\nCommands:\n", filepath.Base(os.Args[0]))
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nGlobal options:\n")
+	flag.PrintDefaults()
+}
+
+// runPlan applies a plan, or only prints it for -dry-run
+func runPlan(conf addons.Conf, p *addons.Plan) error {
+	if dryRun {
+		p.Print(os.Stdout)
+		return nil
+	}
+
+	return addons.Apply(conf, p)
+}
+
+func install(conf addons.Conf, args []string) error {
+	if dryRun {
+		return plan(conf, nil)
+	}
+
+	return addons.Execute(conf)
+}
+
+// update reconciles only the named entries, installed dirs of other entries are left alone
+// ex. wow-addon-cli update Bagnon pfQuest
+func update(conf addons.Conf, args []string) error {
+	if len(args) > 0 {
+		entries, err := addons.SelectEntries(conf, args)
+		if err != nil {
+			return err
+		}
+		conf.Addons = entries
+		conf.KeepUnlisted = true
+	}
+
+	p, err := addons.BuildPlan(conf)
+	defer p.CleanDownloads(conf)
+	if err != nil {
+		return err
+	}
+
+	return runPlan(conf, p)
+}
+
+// remove deletes installed addon dirs, they are backed up first
+// ex. wow-addon-cli remove Bagnon_GuildBank
+func remove(conf addons.Conf, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("remove needs at least one addon name")
+	}
+
+	conf.KeepUnlisted = true
+	p, err := addons.PlanRemove(conf, args)
+	if err != nil {
+		return err
+	}
+
+	return runPlan(conf, p)
+}
+
+func list(conf addons.Conf, args []string) error {
+	installed, err := addons.ListInstalled(conf)
+	if err != nil {
+		return err
+	}
+
+	dirs := []string{}
+	for dir := range installed {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "FOLDER\tENTRY\tREVISION\tINSTALLED\tSOURCE")
+	for _, dir := range dirs {
+		marker := installed[dir]
+		installedAt := ""
+		if !marker.InstalledAt.IsZero() {
+			installedAt = marker.InstalledAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", filepath.Base(dir), marker.Entry, shortRevision(marker.Revision), installedAt, marker.Source)
+	}
+
+	return w.Flush()
+}
+
+func status(conf addons.Conf, args []string) error {
+	statuses, err := addons.Status(conf)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENTRY\tSTATE\tINSTALLED\tREMOTE\tFOLDERS")
+	for _, st := range statuses {
+		folders := []string{}
+		for _, d := range st.Dirs {
+			folders = append(folders, filepath.Base(d))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", st.Entry.DisplayName(), st.State, shortRevision(st.InstalledRevision), shortRevision(st.RemoteRevision), strings.Join(folders, " "))
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, st := range statuses {
+		for _, path := range st.Modified {
+			fmt.Printf("modified locally: %s\n", path)
+		}
+	}
+
+	return nil
+}
+
+// plan prints what a run would change without touching AddOns
+// ex. wow-addon-cli plan -out plan.json
+func plan(conf addons.Conf, args []string) error {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	flagOut := flags.String("out", "", "write the plan as JSON to this file to apply later, keeping its downloads")
+	flagJSON := flags.Bool("json", false, "print the plan as JSON")
+	flags.Parse(args)
+
+	p, err := addons.BuildPlan(conf)
+	if *flagOut == "" || err != nil {
+		defer p.CleanDownloads(conf)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *flagJSON {
+		err = p.WriteJSON(os.Stdout)
+		if err != nil {
+			return err
+		}
+	} else {
+		p.Print(os.Stdout)
+	}
+
+	if *flagOut != "" {
+		fp, err := os.Create(*flagOut)
+		if err != nil {
+			return err
+		}
+		defer fp.Close()
+
+		return p.WriteJSON(fp)
+	}
+
+	return nil
+}
+
+// apply applies a plan file written by plan -out, or plans and applies the config
+// ex. wow-addon-cli apply plan.json
+func apply(conf addons.Conf, args []string) error {
+	if len(args) == 0 {
+		return install(conf, args)
+	}
+
+	p, err := addons.ReadPlan(args[0])
+	if err != nil {
+		return err
+	}
+
+	err = runPlan(conf, p)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		p.CleanDownloads(conf)
+	}
+	return nil
+}
+
+// restore lists backup snapshots, or restores addons from one
+// ex. wow-addon-cli restore 20250801-201500.123456 Bagnon
+func restore(conf addons.Conf, args []string) error {
+	if len(args) == 0 {
+		snapshots, err := addons.ListSnapshots(conf)
+		if err != nil {
+			return err
+		}
+		for _, snap := range snapshots {
+			fmt.Printf("%s\t%s\n", snap.Name, strings.Join(snap.Addons, " "))
+		}
+		return nil
+	}
+
+	conf.SnapshotName = addons.NewSnapshotName()
+	return addons.RestoreSnapshot(conf, args[0], args[1:])
+}
+
+// cache lists or garbage collects the download cache
+// ex. wow-addon-cli cache gc -maxsize 500MB
+func cache(conf addons.Conf, args []string) error {
+	if conf.CachePath == "" {
+		return fmt.Errorf("the download cache is disabled")
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf("cache needs a subcommand: ls or gc")
+	}
+
+	switch args[0] {
+	case "ls":
+		items, err := addons.ListCache(conf)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tREVISION\tSIZE\tLAST USED\tURL")
+		total := int64(0)
+		for _, item := range items {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", item.Kind, shortRevision(item.Revision), formatSize(item.Size), item.LastUsed.Local().Format("2006-01-02 15:04"), item.URL)
+			total += item.Size
+		}
+		err = w.Flush()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d items, %s in %s\n", len(items), formatSize(total), conf.CachePath)
+		return nil
+	case "gc":
+		flags := flag.NewFlagSet("cache gc", flag.ExitOnError)
+		flagMaxSize := flags.String("maxsize", conf.CacheMaxSize, "evict least recently used artifacts down to this size, ex. 500MB")
+		flags.Parse(args[1:])
+
+		maxSize := int64(0)
+		if *flagMaxSize != "" {
+			var err error
+			maxSize, err = addons.ParseSize(*flagMaxSize)
+			if err != nil {
+				return err
+			}
+		}
+
+		freed, err := addons.GCCache(conf, maxSize)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Freed %s\n", formatSize(freed))
+		return nil
+	}
+
+	return fmt.Errorf("unknown cache subcommand %q", args[0])
+}
+
+// search lists catalog addons to add to the config by name
+// ex. wow-addon-cli search questie
+func search(conf addons.Conf, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("search needs a term")
+	}
+
+	found, err := addons.SearchCatalogs(conf, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("No addon matches %q\n", strings.Join(args, " "))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tAUTHOR\tTITLE\tSOURCE\tCATALOG")
+	for _, a := range found {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", a.Name, a.Author, a.Title, a.Source(), a.Catalog)
+	}
+
+	return w.Flush()
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(size)/(1<<30))
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(size)/(1<<10))
+	}
+
+	return fmt.Sprintf("%dB", size)
+}
+
+// shortRevision trims commits and hashes for display, ex. sha256:d8d36e912f2e87 -> sha256:d8d36e912f
+func shortRevision(rev string) string {
+	prefix := ""
+	if i := strings.Index(rev, ":"); i >= 0 {
+		prefix, rev = rev[:i+1], rev[i+1:]
+	}
+	if len(rev) > 10 {
+		rev = rev[:10]
+	}
+
+	return prefix + rev
+}
@@ -1,18 +1,23 @@
 package addons
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/fs"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
-	"github.com/go-git/go-git/v6"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/segmentio/ksuid"
 )
@@ -28,14 +33,67 @@ type UnpackCandidate struct {
 }
 
 type AddonEntry struct {
-	// config strings
-	Git  string
-	Zip  string
-	Url  string
-	Name string
-
-	// hydrated later
-	UniqueName string
+	// config strings, zip is any archive: zip, tar.gz, tar.bz2, tar.xz or 7z
+	Git  string `json:"git,omitempty"`
+	Zip  string `json:"zip,omitempty"`
+	Url  string `json:"url,omitempty"`
+	Name string `json:"name,omitempty"`
+	// a local working copy, copied like a download or with link symlinked into AddOns for live development
+	Path string `json:"path,omitempty"`
+	Link bool   `json:"link,omitempty"`
+	// a release source, the asset of its latest release or of the release matching tag or version is installed.
+	// GitHub owner/repo, GitLab group/repo or Gitea/Forgejo owner/repo, gitlab and gitea also take a project url on another server
+	GitHub string `json:"github,omitempty"`
+	GitLab string `json:"gitlab,omitempty"`
+	Gitea  string `json:"gitea,omitempty"`
+	// addon ids of WoWInterface, Wago Addons and CurseForge, ex. wowinterface = "12345"
+	WoWInterface string `json:"wowinterface,omitempty"`
+	Wago         string `json:"wago,omitempty"`
+	CurseForge   string `json:"curseforge,omitempty"`
+	// pick the release asset matching a glob ex. "*-classic.zip", or made for a game flavor ex. wrath, instead of the config's flavor
+	Asset  string `json:"asset,omitempty"`
+	Flavor string `json:"flavor,omitempty"`
+	// git entries follow the default branch unless pinned to one of a branch, tag or commit
+	Branch string `json:"branch,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	// or follow the highest tag matching a version constraint ex. ^2.4, in a release channel: stable, beta or alpha
+	Version string `json:"version,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	// only look for addons in this subdirectory of the repo or archive, ex. src. A git repo's
+	// mirror is a partial clone that fetches only the files of the subtree.
+	Subdir string `json:"subdir,omitempty"`
+	// install only the addon dirs matching include, if set, and not exclude, ex. exclude = ["Bagnon_VoidStorage"]
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// install addon dirs under another name, their TOC files are renamed to match ex. rename = { "pfQuest-epoch" = "pfQuest" }
+	Rename map[string]string `json:"rename,omitempty"`
+	// git submodules are checked out recursively unless set to false
+	Submodules *bool `json:"submodules,omitempty"`
+	// a git repo's .pkgmeta externals, ignore, package-as and move-folders are applied unless set to false
+	Pkgmeta *bool `json:"pkgmeta,omitempty"`
+
+	// hydrated later, never read from a config or catalog
+	UniqueName string `json:"unique_name" toml:"-"`
+	// the git commit or archive etag/hash that was fetched
+	Revision string `json:"revision,omitempty" toml:"-"`
+	// the tag a version constraint resolved to
+	ResolvedTag string `json:"resolved_tag,omitempty" toml:"-"`
+	// the archive url after redirects, and its hash
+	ResolvedURL string `json:"resolved_url,omitempty" toml:"-"`
+	SHA256      string `json:"sha256,omitempty" toml:"-"`
+	// set for frozen installs, the entry must install exactly this
+	Locked *LockEntry `json:"locked,omitempty" toml:"-"`
+}
+
+// clearResolved drops what a run fills in, a JSON catalog could set them like plans do
+func (entry *AddonEntry) clearResolved() {
+	entry.UniqueName = ""
+	entry.Revision = ""
+	entry.ResolvedTag = ""
+	entry.ResolvedURL = ""
+	entry.SHA256 = ""
+	entry.Locked = nil
 }
 
 func (entry *AddonEntry) Hydrate() error {
@@ -46,18 +104,214 @@ func (entry *AddonEntry) Hydrate() error {
 		if err != nil {
 			return err
 		}
-		ext := filepath.Ext(u.Path)
-		switch ext {
-		case ".git":
+		if filepath.Ext(u.Path) == ".git" {
 			entry.Git = entry.Url
-		case ".zip":
+		} else if util.ArchiveFormatFromName(u.Path) != "" {
 			entry.Zip = entry.Url
+		} else if p := localFilePath(entry.Url); p != "" {
+			// a local dir that isn't a bare repo is a working copy
+			entry.Path = p
+		}
+	}
+
+	err := entry.hydrateLocal()
+	if err != nil {
+		return err
+	}
+
+	if entry.Subdir != "" {
+		entry.Subdir = filepath.Clean(filepath.FromSlash(entry.Subdir))
+		if !filepath.IsLocal(entry.Subdir) {
+			return fmt.Errorf("entry %v: subdir %q must be a relative path inside the source", entry.SourceKey(), entry.Subdir)
+		}
+	}
+
+	err = entry.validateFilters()
+	if err != nil {
+		return err
+	}
+	err = entry.validateRelease()
+	if err != nil {
+		return err
+	}
+	err = entry.validateRename()
+	if err != nil {
+		return err
+	}
+
+	return entry.validateGitRef()
+}
+
+func (entry AddonEntry) validateGitRef() error {
+	if entry.Channel != "" {
+		if _, ok := channelRank[entry.Channel]; !ok {
+			return fmt.Errorf("entry %v: unknown channel %q, use stable, beta or alpha", entry.SourceKey(), entry.Channel)
+		}
+	}
+	if entry.Version != "" {
+		_, err := ParseVersionConstraint(entry.Version)
+		if err != nil {
+			return fmt.Errorf("entry %v: %w", entry.SourceKey(), err)
+		}
+	}
+
+	pins := 0
+	if entry.FollowsVersion() {
+		pins++
+	}
+	for _, pin := range []string{entry.Branch, entry.Tag, entry.Commit} {
+		if pin != "" {
+			pins++
+		}
+	}
+
+	if pins == 0 {
+		return nil
+	}
+	if entry.Git == "" && !entry.IsRelease() {
+		return fmt.Errorf("entry %v: branch, tag, commit and version only apply to git and release sources", entry.SourceKey())
+	}
+	if pins > 1 {
+		return fmt.Errorf("entry %v: only one of branch, tag, commit and version can be set", entry.SourceKey())
+	}
+	if entry.Commit != "" {
+		notHex := strings.Trim(entry.Commit, "0123456789abcdefABCDEF") != ""
+		if notHex || len(entry.Commit) < 7 || len(entry.Commit) > 40 {
+			return fmt.Errorf("entry %v: commit %q is not a commit hash", entry.SourceKey(), entry.Commit)
 		}
 	}
 
 	return nil
 }
 
+// FollowsVersion is set for entries that install the highest tag matching a version and channel
+func (entry AddonEntry) FollowsVersion() bool {
+	return entry.Version != "" || entry.Channel != ""
+}
+
+// InstallFingerprint records the config options that change what an entry installs, ex. "subdir=src".
+// An installed entry whose fingerprint differs is reinstalled even at the same revision.
+func (entry AddonEntry) InstallFingerprint() string {
+	options := []string{}
+	if entry.Subdir != "" {
+		options = append(options, "subdir="+filepath.ToSlash(entry.Subdir))
+	}
+	if len(entry.Include) > 0 {
+		options = append(options, "include="+strings.Join(entry.Include, ","))
+	}
+	if len(entry.Exclude) > 0 {
+		options = append(options, "exclude="+strings.Join(entry.Exclude, ","))
+	}
+	if len(entry.Rename) > 0 {
+		options = append(options, "rename="+entry.renameFingerprint())
+	}
+	if !entry.FetchSubmodules() {
+		options = append(options, "submodules=false")
+	}
+	if !entry.ApplyPkgmeta() {
+		options = append(options, "pkgmeta=false")
+	}
+	if entry.Link {
+		options = append(options, "link")
+	}
+
+	return strings.Join(options, ";")
+}
+
+// GitRef is what a git entry is pinned to, ex. "tag:v1.2" or "version:^2.4@beta", empty when it follows the default branch
+func (entry AddonEntry) GitRef() string {
+	switch {
+	case entry.FollowsVersion():
+		version := entry.Version
+		if version == "" {
+			version = "*"
+		}
+		if entry.Channel != "" {
+			return "version:" + version + "@" + entry.Channel
+		}
+		return "version:" + version
+	case entry.Branch != "":
+		return "branch:" + entry.Branch
+	case entry.Tag != "":
+		return "tag:" + entry.Tag
+	case entry.Commit != "":
+		return "commit:" + entry.Commit
+	}
+
+	return ""
+}
+
+// SourceKey identifies where an entry installs from, it is recorded in the marker of each dir it installs
+func (entry AddonEntry) SourceKey() string {
+	if entry.IsRelease() {
+		return entry.releaseKey()
+	}
+	if entry.Git != "" {
+		return entry.Git
+	}
+	if entry.Path != "" {
+		return entry.Path
+	}
+
+	return entry.Zip
+}
+
+// ownsSource is true when a marker or lock entry of source and subdir belongs to the entry.
+// Entries of one repo with different subdirs install different dirs, each owns its own.
+func (entry AddonEntry) ownsSource(source string, subdir string) bool {
+	return source != "" && source == entry.SourceKey() && subdir == filepath.ToSlash(entry.Subdir)
+}
+
+// SourceHost is the host an entry fetches from, empty for local paths
+func (entry AddonEntry) SourceHost() string {
+	// release sources are limited per API, ex. github
+	if entry.IsRelease() {
+		return entry.releaseHost()
+	}
+
+	u, err := url.Parse(entry.SourceKey())
+	if err != nil {
+		return ""
+	}
+
+	return u.Host
+}
+
+// Log returns a logger that attributes lines to the entry, fetches run concurrently
+func (entry AddonEntry) Log() *zerolog.Logger {
+	l := log.With().Str("entry", entry.DisplayName()).Logger()
+	return &l
+}
+
+// DisplayName is a short name for the entry in logs and manifests
+func (entry AddonEntry) DisplayName() string {
+	if entry.Name != "" {
+		return entry.Name
+	}
+
+	if entry.Git != "" {
+		return entry.CloneSubdirName()
+	}
+
+	if entry.Path != "" {
+		return filepath.Base(entry.Path)
+	}
+
+	if entry.IsRelease() {
+		_, project, _ := strings.Cut(entry.releaseKey(), ":")
+		return path.Base(strings.TrimSuffix(project, ".git"))
+	}
+
+	if entry.Zip != "" {
+		u, err := url.Parse(entry.Zip)
+		if err == nil {
+			return util.TrimArchiveExt(filepath.Base(u.Path))
+		}
+	}
+
+	return entry.SourceKey()
+}
+
 func (entry AddonEntry) CloneSubdirName() string {
 	// if entry name is specified, force it to be that!
 	if entry.Name != "" {
@@ -79,6 +333,53 @@ type Conf struct {
 	PrecleanBliz      bool
 	SkipCleanPrefixes []string
 	Addons            []AddonEntry `toml:"addons"`
+
+	// backup retention, keep N snapshots and/or drop snapshots older than max age ex. 30d
+	BackupKeep   int
+	BackupMaxAge string
+
+	// hydrated later, the backup snapshot for the current run
+	SnapshotName string `toml:"-"`
+	// hydrated later, the lockfile next to the config and whether to install exactly what it records
+	LockPath string `toml:"-"`
+	Frozen   bool   `toml:"-"`
+	// hydrated later, the keys set on each config entry, only they override a catalog's entry
+	EntryKeys [][]string `toml:"-"`
+	// persistent download cache, evicted down to CacheMaxSize ex. 1GB after each run
+	CachePath    string
+	CacheMaxSize string
+
+	// the game flavor release assets are picked for, ex. wrath, entries may override it
+	Flavor string
+	// GitHub API base url and token for github entries, the token defaults to GITHUB_TOKEN
+	GitHubAPI   string
+	GitHubToken string
+	// GitLab and Gitea/Forgejo servers of entries given as project paths, and their tokens
+	// (default GITLAB_TOKEN and GITEA_TOKEN). Tokens are only sent to their own server.
+	GitLabURL   string
+	GitLabToken string
+	GiteaURL    string
+	GiteaToken  string
+	// WoWInterface and Wago Addons APIs, and their keys (default WOWINTERFACE_API_KEY and WAGO_API_KEY).
+	// Wago needs a key, WoWInterface doesn't.
+	WoWInterfaceAPI string
+	WoWInterfaceKey string
+	WagoAPI         string
+	WagoKey         string
+	// addon indexes, files or urls, entries with only a name install the catalog's source for it
+	Catalogs []string
+	// CurseForge API and its key (default CURSEFORGE_API_KEY), required for curseforge entries
+	CurseForgeAPI string
+	CurseForgeKey string
+	// install only from the cache, no network
+	Offline bool `toml:"-"`
+
+	// concurrent fetches, in total and against the same host
+	Workers     int
+	HostWorkers int
+
+	// hydrated later, set when only some entries are processed so installed dirs of the others are kept
+	KeepUnlisted bool `toml:"-"`
 }
 
 var DefaultSkipCleanPrefixes = []string{
@@ -91,13 +392,15 @@ func (c Conf) DownloadUniqueDir(entry AddonEntry) (string, error) {
 	return filepath.Join(c.DownloadPath, entry.UniqueName), nil
 }
 
-func (c Conf) DestZip(entry AddonEntry) (string, error) {
-	return filepath.Join(c.DownloadPath, entry.UniqueName) + ".zip", nil
+// DestArchive is where an entry's archive is downloaded to, its format is detected after
+func (c Conf) DestArchive(entry AddonEntry) (string, error) {
+	return filepath.Join(c.DownloadPath, entry.UniqueName) + ".archive", nil
 }
 
-func FetchEntry(conf Conf, entry AddonEntry) ([]string, error) {
+// FetchEntry downloads an entry into its download unique dir and sets entry.Revision
+func FetchEntry(ctx context.Context, conf Conf, entry *AddonEntry) ([]string, error) {
 	cleanupPaths := []string{}
-	downloadUniqueDir, err := conf.DownloadUniqueDir(entry)
+	downloadUniqueDir, err := conf.DownloadUniqueDir(*entry)
 	if err != nil {
 		return cleanupPaths, err
 	}
@@ -109,82 +412,229 @@ func FetchEntry(conf Conf, entry AddonEntry) ([]string, error) {
 	cleanupPaths = append(cleanupPaths, downloadUniqueDir)
 
 	if entry.Git != "" {
-		clonePath := filepath.Join(downloadUniqueDir, entry.CloneSubdirName())
-		log.Debug().Msgf("Entry cloning git: %s to %s", entry.Git, clonePath)
-
-		progressBuf := new(strings.Builder)
-		_, err = git.PlainClone(clonePath, &git.CloneOptions{
-			URL:      entry.Git,
-			Depth:    1,
-			Tags:     git.NoTags,
-			Progress: progressBuf,
-		})
+		return cleanupPaths, fetchGit(ctx, conf, entry, downloadUniqueDir)
+	}
 
-		if err != nil {
-			log.Debug().Msgf("Progress buffer output: %s", progressBuf.String())
-			return cleanupPaths, err
+	if entry.Path != "" {
+		return cleanupPaths, fetchPath(entry, downloadUniqueDir)
+	}
+
+	// offline, the most recent release in the cache is installed
+	if entry.IsRelease() {
+		if !conf.Offline {
+			err = resolveRelease(ctx, conf, entry)
+			if err != nil {
+				return cleanupPaths, err
+			}
 		}
+		paths, err := fetchZip(ctx, conf, entry, downloadUniqueDir)
+		return append(cleanupPaths, paths...), err
+	}
 
-		return cleanupPaths, err
+	if p := localFilePath(entry.Zip); p != "" {
+		return cleanupPaths, fetchLocalArchive(entry, p, downloadUniqueDir)
 	}
 
 	if entry.Zip != "" {
-		client := http.Client{
-			Timeout: time.Second * 20,
-		}
+		paths, err := fetchZip(ctx, conf, entry, downloadUniqueDir)
+		return append(cleanupPaths, paths...), err
+	}
+
+	return cleanupPaths, fmt.Errorf("nothing to fetch")
+}
 
-		resp, err := client.Get(entry.Zip)
+// fetchZip downloads the entry's archive, or takes it from the cache, and extracts it to the download unique dir
+func fetchZip(ctx context.Context, conf Conf, entry *AddonEntry, downloadUniqueDir string) ([]string, error) {
+	cleanupPaths := []string{}
+
+	var cached *CacheItem
+	var err error
+	if entry.Locked != nil && entry.Locked.SHA256 != "" {
+		cached, err = FindCached(conf, CacheArchive, entry.SourceKey(), "sha256:"+entry.Locked.SHA256)
+	} else if conf.Offline {
+		cached, err = FindCached(conf, CacheArchive, entry.SourceKey(), "")
+	}
+	if err != nil {
+		return cleanupPaths, err
+	}
+
+	if cached == nil && conf.Offline {
+		return cleanupPaths, fmt.Errorf("offline and %v is not in the cache", entry.SourceKey())
+	}
+
+	archivePath := ""
+	format := util.ArchiveFormat("")
+	if cached != nil {
+		archivePath = conf.CachedPath(*cached)
+		entry.Log().Debug().Msgf("Using cached archive %v", archivePath)
+		entry.SHA256 = cached.SHA256
+		entry.Revision = cached.Revision
+		entry.ResolvedURL = cached.ResolvedURL
+		format = util.ArchiveFormat(cached.Format)
+	} else {
+		archivePath, err = conf.DestArchive(*entry)
 		if err != nil {
 			return cleanupPaths, err
 		}
-		defer resp.Body.Close()
+		cleanupPaths = append(cleanupPaths, archivePath)
 
-		writePath, err := conf.DestZip(entry)
+		fromCache, fromCacheFormat, err := downloadZip(ctx, conf, entry, archivePath)
 		if err != nil {
 			return cleanupPaths, err
 		}
-		fp, err := os.Create(writePath)
-		if err != nil {
-			return cleanupPaths, err
+		if fromCache != "" {
+			archivePath = fromCache
 		}
-		defer fp.Close()
+		format = fromCacheFormat
+	}
 
-		log.Debug().Msgf("Writing %s to %s", entry.Zip, writePath)
-		writtenBytes, err := io.Copy(fp, resp.Body)
+	if entry.Locked != nil && entry.Locked.SHA256 != "" && entry.Locked.SHA256 != entry.SHA256 {
+		return cleanupPaths, fmt.Errorf("archive %v has sha256 %v, the lockfile has %v", entry.SourceKey(), entry.SHA256, entry.Locked.SHA256)
+	}
+
+	// caches from older versions don't record the format
+	if format == "" {
+		format, err = util.DetectArchiveFormat(archivePath, entry.Zip)
 		if err != nil {
 			return cleanupPaths, err
 		}
-		log.Debug().Msgf("Wrote %d bytes", writtenBytes)
-		cleanupPaths = append(cleanupPaths, writePath)
+	}
 
-		// extract do the download unique dir
-		destDir := downloadUniqueDir
+	// extract do the download unique dir
+	destDir := downloadUniqueDir
 
-		err = util.Unzip(writePath, destDir)
-		if err != nil {
-			return cleanupPaths, err
-		}
+	entry.Log().Debug().Msgf("Extracting %v archive %v", format, archivePath)
+	err = util.Extract(archivePath, format, destDir)
+	if err != nil {
+		return cleanupPaths, err
+	}
+
+	entry.Log().Debug().Msg("Extraction complete.")
+	return cleanupPaths, nil
+}
 
-		log.Debug().Msg("Extraction complete.")
-		return cleanupPaths, nil
+// downloadZip writes the entry's archive to writePath, detects its format and caches it. If the server says the
+// most recently cached archive for the url is still current, the cached path is returned instead.
+func downloadZip(ctx context.Context, conf Conf, entry *AddonEntry, writePath string) (string, util.ArchiveFormat, error) {
+	client := http.Client{
+		Timeout: time.Second * 20,
 	}
 
-	return cleanupPaths, fmt.Errorf("nothing to fetch")
+	// release asset urls redirect to expiring download links, the asset is resolved again instead
+	zipURL := entry.Zip
+	if entry.Locked != nil && entry.Locked.URL != "" && !entry.IsRelease() {
+		zipURL = entry.Locked.URL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, zipURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	latest, err := FindCached(conf, CacheArchive, entry.SourceKey(), "")
+	if err != nil {
+		return "", "", err
+	}
+	// the latest cached archive of a release source may be another release
+	if latest != nil && latest.ETag != "" && entry.Locked == nil && !entry.IsRelease() {
+		req.Header.Set("If-None-Match", latest.ETag)
+	}
+	// assets of private projects need the token of their server
+	for k, v := range conf.releaseAuth(*entry, zipURL) {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && latest != nil {
+		entry.Log().Debug().Msgf("Not modified, using cached archive for etag %v", latest.ETag)
+		entry.SHA256 = latest.SHA256
+		entry.Revision = latest.Revision
+		entry.ResolvedURL = latest.ResolvedURL
+		return conf.CachedPath(*latest), util.ArchiveFormat(latest.Format), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("unexpected status downloading %v: %v", zipURL, resp.Status)
+	}
+	entry.ResolvedURL = resp.Request.URL.String()
+
+	fp, err := os.Create(writePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer fp.Close()
+
+	entry.Log().Debug().Msgf("Writing %s to %s", zipURL, writePath)
+	hash := sha256.New()
+	writtenBytes, err := io.Copy(io.MultiWriter(fp, hash), resp.Body)
+	if err != nil {
+		return "", "", err
+	}
+	entry.Log().Debug().Msgf("Wrote %d bytes", writtenBytes)
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	// a release's revision is its tag and asset, known before downloading
+	if !entry.IsRelease() {
+		entry.Revision = archiveRevision(resp.Header.Get("ETag"), entry.SHA256)
+	}
+
+	err = fp.Close()
+	if err != nil {
+		return "", "", err
+	}
+	format, err := util.DetectArchiveFormat(writePath, resp.Header.Get("Content-Type"), contentDispositionFilename(resp.Header.Get("Content-Disposition")), resp.Request.URL.Path)
+	if err != nil {
+		return "", "", fmt.Errorf("download of %v: %w", zipURL, err)
+	}
+
+	err = StoreArchive(conf, *entry, writePath, resp.Header.Get("ETag"), format)
+	if err != nil {
+		entry.Log().Warn().Err(err).Msg("could not cache archive")
+	}
+
+	return "", format, nil
+}
+
+// contentDispositionFilename is the file name a server suggests for a download, ex. attachment; filename="Bagnon.tar.gz"
+func contentDispositionFilename(header string) string {
+	_, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		return ""
+	}
+
+	return params["filename"]
 }
 
-func UnpackEntry(conf Conf, entry AddonEntry) error {
-	log.Debug().Msgf("Unpacking %+v", entry)
+// DiscoverInstalls finds the addon dirs in an entry's download and the names they install as
+func DiscoverInstalls(conf Conf, entry AddonEntry) ([]AddonInstall, error) {
+	entry.Log().Debug().Msgf("Unpacking %+v", entry)
+	installs := []AddonInstall{}
 	downloadUniqueDir, err := conf.DownloadUniqueDir(entry)
 	if err != nil {
-		return err
+		return installs, err
+	}
+
+	// linked entries install the addon dirs of the working copy itself
+	searchDir := downloadUniqueDir
+	if entry.Link {
+		searchDir = entry.Path
+	}
+
+	root, err := discoveryRoot(entry, searchDir)
+	if err != nil {
+		return installs, err
 	}
 
 	tocFiles := []*TOCFile{}
 
 	// find the .toc files that mark each addon directory root
-	err = filepath.WalkDir(downloadUniqueDir, func(path string, d fs.DirEntry, err error) error {
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			log.Debug().Msgf("walking error: %v", err)
+			entry.Log().Debug().Msgf("walking error: %v", err)
 			return err
 		}
 
@@ -210,12 +660,12 @@ func UnpackEntry(conf Conf, entry AddonEntry) error {
 		// Open the file for reading.
 		toc, err := BuildTOCFromFile(path)
 		if err != nil {
-			log.Warn().Err(err).Msg("error building TOC file, keep walking")
+			entry.Log().Warn().Err(err).Msg("error building TOC file, keep walking")
 			return nil
 		}
 		// TODO awkward use of nil and error. have to define what's skippable error
 		if toc == nil {
-			log.Warn().Msgf("skipped building toc file %+v", path)
+			entry.Log().Warn().Msgf("skipped building toc file %+v", path)
 			return nil
 		}
 
@@ -225,11 +675,11 @@ func UnpackEntry(conf Conf, entry AddonEntry) error {
 	})
 
 	if err != nil {
-		return err
+		return installs, err
 	}
 
 	if len(tocFiles) == 0 {
-		return fmt.Errorf("No toc files detected, nothing to unpack")
+		return installs, fmt.Errorf("No toc files detected, nothing to unpack")
 	}
 
 	// find the "shallowest" .toc file which becomes the "root"
@@ -258,7 +708,7 @@ func UnpackEntry(conf Conf, entry AddonEntry) error {
 	}
 
 	if minDepth == -1 {
-		return fmt.Errorf("Could not deterine mindepth")
+		return installs, fmt.Errorf("Could not deterine mindepth")
 	}
 
 	for _, toc := range tocFiles {
@@ -267,14 +717,14 @@ func UnpackEntry(conf Conf, entry AddonEntry) error {
 		}
 	}
 
-	log.Debug().Msgf("Min depth tocs %+v", minDepthTocs)
+	entry.Log().Debug().Msgf("Min depth tocs %+v", minDepthTocs)
 
 	groups, err := GroupTOCFiles(minDepthTocs)
 	if err != nil {
-		return err
+		return installs, err
 	}
 
-	log.Debug().Msgf("To unpack toc groups %+v", groups)
+	entry.Log().Debug().Msgf("To unpack toc groups %+v", groups)
 
 	// TODO only keep one of the to unpack entries
 	// the the dest addon name dir should be based on the entry's Name if it exists
@@ -284,110 +734,78 @@ func UnpackEntry(conf Conf, entry AddonEntry) error {
 	for _, grp := range groups {
 		addonName, err := grp.AddonName()
 		if err != nil {
-			log.Warn().Err(err).Msg("Error getting addon name from group")
+			entry.Log().Warn().Err(err).Msg("Error getting addon name from group")
 			continue
 		}
 
 		if addonName == "" {
-			log.Warn().Msg("Empty addon name")
+			entry.Log().Warn().Msg("Empty addon name")
 			continue
 		}
 
-		destAddonDir := filepath.Join(conf.AddonsPath, addonName)
 		tocSrcDir, err := grp.Dir()
 		if err != nil {
-			log.Warn().Err(err).Msg("Could not get TOC dir")
+			entry.Log().Warn().Err(err).Msg("Could not get TOC dir")
 			continue
 		}
 
-		log.Debug().Msgf("Removing dest dir %v", destAddonDir)
-		err = os.RemoveAll(destAddonDir)
-		if err != nil {
-			return err
-		}
-
-		log.Debug().Msgf("Making directories %v", destAddonDir)
-		err = os.MkdirAll(destAddonDir, 0755)
-		if err != nil {
-			return err
-		}
-		log.Debug().Msgf("Copying %v to %v", tocSrcDir, destAddonDir)
-		err = util.CopyDir(destAddonDir, tocSrcDir)
-		if err != nil {
-			return err
-		}
-
-		// create a marker file
-		markerDest := filepath.Join(destAddonDir, MARKER)
-		log.Debug().Msgf("Creating marker file %s", markerDest)
-		_, err = os.Create(markerDest)
-		if err != nil {
-			return err
-		}
+		installs = append(installs, AddonInstall{
+			Name:   addonName,
+			SrcDir: tocSrcDir,
+		})
 	}
 
-	return nil
+	return installs, nil
 }
 
-func CleanDownload(conf Conf, cleanupPaths []string) error {
-	for _, d := range cleanupPaths {
-		log.Debug().Msgf("Removing dir for clean up %v", d)
-		err := os.RemoveAll(d)
-		if err != nil {
-			return err
-		}
+// discoveryRoot is where to look for TOC files, the entry's subdir if it has one.
+// Archives usually wrap everything in a top level dir, ex. Bagnon-main/src, so the subdir is also looked for in there.
+func discoveryRoot(entry AddonEntry, downloadUniqueDir string) (string, error) {
+	if entry.Subdir == "" {
+		return downloadUniqueDir, nil
 	}
 
-	return nil
-}
-
-func RemoveNonBlizDirs(conf Conf) error {
-	matches, err := filepath.Glob(conf.AddonsPath + SEP + "*")
+	candidates := []string{filepath.Join(downloadUniqueDir, entry.Subdir)}
+	children, err := os.ReadDir(downloadUniqueDir)
 	if err != nil {
-		return err
+		return "", err
 	}
-	for _, dir := range matches {
-		isDir, err := util.IsDirectory(dir)
-		if err != nil {
-			return err
+	for _, child := range children {
+		if child.IsDir() {
+			candidates = append(candidates, filepath.Join(downloadUniqueDir, child.Name(), entry.Subdir))
 		}
+	}
 
-		// only clean up dirs
-		if !isDir {
-			continue
+	for _, candidate := range candidates {
+		isDir, _ := util.IsDirectory(candidate)
+		if isDir {
+			entry.Log().Debug().Msgf("Looking for addons in %v", candidate)
+			return candidate, nil
 		}
+	}
 
-		markerFile := filepath.Join(dir, MARKER)
-		log.Debug().Msgf("Checking for marker at %v", markerFile)
-		exists, _ := util.FileExists(markerFile)
-		if !exists {
-			continue
-		}
+	return "", fmt.Errorf("subdir %v not found in %v", entry.Subdir, entry.SourceKey())
+}
 
-		log.Debug().Msgf("Found marker dir for cleaning %v", dir)
+// InstallEntry stages and swaps each addon dir of an entry into AddOns
+func InstallEntry(txn *Transaction, entry AddonEntry, installs []AddonInstall) error {
+	marker := NewMarker(entry)
 
-		base := filepath.Base(dir)
-		shouldSkip := false
-		for _, prefix := range DefaultSkipCleanPrefixes {
-			if strings.HasPrefix(base, prefix) {
-				shouldSkip = true
-			}
-		}
-		for _, prefix := range conf.SkipCleanPrefixes {
-			if strings.HasPrefix(base, prefix) {
-				shouldSkip = true
+	for _, inst := range installs {
+		if entry.Link {
+			err := txn.Link(inst.Name, inst.SrcDir, marker)
+			if err != nil {
+				return err
 			}
-		}
-		if strings.HasPrefix(base, ".") {
-			shouldSkip = true
+			continue
 		}
 
-		if shouldSkip {
-			continue
+		stageDir, err := txn.Stage(inst.Name, inst.SrcDir, marker)
+		if err != nil {
+			return err
 		}
 
-		log.Debug().Msgf("Removing marked dir %v", dir)
-		err = os.RemoveAll(dir)
+		err = txn.Install(inst.Name, stageDir)
 		if err != nil {
 			return err
 		}
@@ -396,46 +814,30 @@ func RemoveNonBlizDirs(conf Conf) error {
 	return nil
 }
 
-func Execute(conf Conf) error {
-	err := RemoveNonBlizDirs(conf)
-	if err != nil {
-		return fmt.Errorf("error cleaning bliz dirs %+v", err)
-	}
-
-	for _, entry := range conf.Addons {
-		log.Info().Msgf("Processing entry: %+v", entry)
-
-		// normalize name from Git and other keys
-		err := entry.Hydrate()
+func CleanDownload(conf Conf, cleanupPaths []string) error {
+	for _, d := range cleanupPaths {
+		log.Debug().Msgf("Removing dir for clean up %v", d)
+		err := os.RemoveAll(d)
 		if err != nil {
-			log.Warn().Err(err).Msgf("error hydrating name, skipping: %+v", entry)
-			continue
-		}
-
-		if entry.UniqueName == "" {
-			log.Warn().Msgf("entry name is empty, skipping: %+v", entry)
-			continue
+			return err
 		}
+	}
 
-		cleanUpPaths, err := FetchEntry(conf, entry)
-		defer func() {
-			err := CleanDownload(conf, cleanUpPaths)
-			if err != nil {
-				log.Error().Err(err).Msgf("error cleaning up download for entry %+v", entry)
-			}
-		}()
-		if err != nil {
-			log.Warn().Err(err).Msgf("error fetching entry: %+v, error: %v", entry, err)
-			continue
-		}
+	return nil
+}
 
-		err = UnpackEntry(conf, entry)
-		if err != nil {
-			log.Warn().Msgf("error unpacking entry: %+v, error: %v", entry, err)
-			continue
-		}
+// Execute plans and applies the config in one go
+func Execute(conf Conf) error {
+	err := RecoverTransaction(conf)
+	if err != nil {
+		return fmt.Errorf("error recovering previous run: %w", err)
+	}
 
-		log.Info().Msgf("Done processing entry: %+v", entry)
+	plan, err := BuildPlan(conf)
+	defer plan.CleanDownloads(conf)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	return Apply(conf, plan)
 }
@@ -0,0 +1,234 @@
+package addons
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// snapshot dir names are timestamps so they sort oldest to newest. New names have microseconds so runs
+// started in the same second get their own snapshot, parsing the format also takes older names without them.
+const (
+	SNAPSHOT_TIME_FORMAT = "20060102-150405"
+	SNAPSHOT_NAME_FORMAT = SNAPSHOT_TIME_FORMAT + ".000000"
+)
+
+const DefaultBackupKeep = 5
+
+type Snapshot struct {
+	Name   string
+	Path   string
+	Time   time.Time
+	Addons []string
+}
+
+func NewSnapshotName() string {
+	return time.Now().Format(SNAPSHOT_NAME_FORMAT)
+}
+
+// BackupAddonDir archives an addon dir into the current run's snapshot before it is removed
+// ex. .backups/20250801-201500.123456/Bagnon.zip
+func BackupAddonDir(conf Conf, dir string) error {
+	if conf.BackupPath == "" || conf.SnapshotName == "" {
+		return nil
+	}
+
+	exists, err := util.FileExists(dir)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	// a linked working copy is not removed with its link
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		log.Debug().Msgf("Not backing up link %v", dir)
+		return nil
+	}
+
+	dest := filepath.Join(conf.BackupPath, conf.SnapshotName, filepath.Base(dir)+".zip")
+	log.Debug().Msgf("Backing up %v to %v", dir, dest)
+	err = util.ZipDir(dir, dest)
+	if err != nil {
+		return fmt.Errorf("error backing up %v: %w", dir, err)
+	}
+
+	return nil
+}
+
+// ListSnapshots returns the snapshots in the backup path, oldest first
+func ListSnapshots(conf Conf) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+
+	dirEntries, err := os.ReadDir(conf.BackupPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return snapshots, nil
+		}
+		return snapshots, err
+	}
+
+	for _, d := range dirEntries {
+		if !d.IsDir() {
+			continue
+		}
+
+		t, err := time.ParseInLocation(SNAPSHOT_TIME_FORMAT, d.Name(), time.Local)
+		if err != nil {
+			log.Debug().Msgf("Skipping non snapshot dir %v", d.Name())
+			continue
+		}
+
+		snap := Snapshot{
+			Name: d.Name(),
+			Path: filepath.Join(conf.BackupPath, d.Name()),
+			Time: t,
+		}
+
+		archives, err := filepath.Glob(filepath.Join(snap.Path, "*.zip"))
+		if err != nil {
+			return snapshots, err
+		}
+		for _, a := range archives {
+			snap.Addons = append(snap.Addons, util.RemoveExt(filepath.Base(a)))
+		}
+
+		snapshots = append(snapshots, snap)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name < snapshots[j].Name
+	})
+
+	return snapshots, nil
+}
+
+// PruneBackups applies the retention policy, keeping at most BackupKeep snapshots
+// and dropping snapshots older than BackupMaxAge. The current run's snapshot is always kept.
+func PruneBackups(conf Conf) error {
+	keep := conf.BackupKeep
+	if keep == 0 {
+		keep = DefaultBackupKeep
+	}
+
+	maxAge := time.Duration(0)
+	if conf.BackupMaxAge != "" {
+		var err error
+		maxAge, err = ParseAge(conf.BackupMaxAge)
+		if err != nil {
+			return err
+		}
+	}
+
+	snapshots, err := ListSnapshots(conf)
+	if err != nil {
+		return err
+	}
+
+	for i, snap := range snapshots {
+		if snap.Name == conf.SnapshotName {
+			continue
+		}
+
+		// negative keep disables the count limit
+		tooMany := keep > 0 && len(snapshots)-i > keep
+		tooOld := maxAge > 0 && time.Since(snap.Time) > maxAge
+		if !(tooMany || tooOld) {
+			continue
+		}
+
+		log.Debug().Msgf("Pruning backup snapshot %v", snap.Path)
+		err = os.RemoveAll(snap.Path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RestoreSnapshot puts the archived addon dirs of a snapshot back into AddOns.
+// If names is empty, every addon in the snapshot is restored.
+// Dirs being overwritten are backed up into the current run's snapshot first.
+func RestoreSnapshot(conf Conf, name string, names []string) error {
+	snapshots, err := ListSnapshots(conf)
+	if err != nil {
+		return err
+	}
+
+	var snap *Snapshot
+	for i := range snapshots {
+		if snapshots[i].Name == name {
+			snap = &snapshots[i]
+		}
+	}
+	if snap == nil {
+		return fmt.Errorf("no backup snapshot named %v in %v", name, conf.BackupPath)
+	}
+
+	toRestore := snap.Addons
+	if len(names) > 0 {
+		toRestore = []string{}
+		for _, n := range names {
+			found := false
+			for _, a := range snap.Addons {
+				if a == n {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("addon %v is not in backup snapshot %v", n, snap.Name)
+			}
+			toRestore = append(toRestore, n)
+		}
+	}
+
+	for _, addonName := range toRestore {
+		destAddonDir := filepath.Join(conf.AddonsPath, addonName)
+
+		err = BackupAddonDir(conf, destAddonDir)
+		if err != nil {
+			return err
+		}
+
+		log.Debug().Msgf("Removing dest dir %v", destAddonDir)
+		err = os.RemoveAll(destAddonDir)
+		if err != nil {
+			return err
+		}
+
+		archive := filepath.Join(snap.Path, addonName+".zip")
+		log.Info().Msgf("Restoring %v from snapshot %v", addonName, snap.Name)
+		err = util.Unzip(archive, conf.AddonsPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseAge parses a duration, additionally allowing a day suffix ex. 30d
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: %w", s, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(s)
+}
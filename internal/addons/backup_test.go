@@ -0,0 +1,41 @@
+package addons
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSnapshotNames(t *testing.T) {
+	first := NewSnapshotName()
+	second := NewSnapshotName()
+	for second == first {
+		second = NewSnapshotName()
+	}
+	if second < first {
+		t.Errorf("snapshot %v sorts before the earlier %v", second, first)
+	}
+
+	conf := Conf{BackupPath: t.TempDir()}
+	// names from before snapshots had microseconds are still listed
+	names := []string{"20250801-201500", second, first, "20250801-201500.000001", "not-a-snapshot"}
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(conf.BackupPath, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := ListSnapshots(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, snap := range snapshots {
+		got = append(got, snap.Name)
+	}
+	want := []string{"20250801-201500", "20250801-201500.000001", first, second}
+	if !slices.Equal(got, want) {
+		t.Errorf("got snapshots %v, want %v", got, want)
+	}
+}
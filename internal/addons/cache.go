@@ -0,0 +1,484 @@
+package addons
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+const CACHE_INDEX = "index.json"
+
+// CACHE_INDEX_LOCK is held by the run changing the index, runs sharing a cache take turns
+const CACHE_INDEX_LOCK = "index.lock"
+
+// a lock older than this was left behind by a run that crashed
+const cacheLockStale = 2 * time.Minute
+
+// gc leaves unindexed files this recent alone, another run may still be storing them
+const cacheStoreGrace = time.Hour
+
+const (
+	CacheArchive = "archive"
+	CacheGit     = "git"
+)
+
+// CacheItem is one cached artifact, an archive keyed by its sha256 or a bare git mirror keyed by url
+type CacheItem struct {
+	Kind string `json:"kind"`
+	// URL is the source url the artifact was fetched from
+	URL         string `json:"url"`
+	Revision    string `json:"revision"`
+	ETag        string `json:"etag,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	ResolvedURL string `json:"resolved_url,omitempty"`
+	// Format is the archive format, ex. tar.gz
+	Format string `json:"format,omitempty"`
+	// Path is relative to the cache dir
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+type CacheIndex struct {
+	Items []CacheItem `json:"items"`
+}
+
+// fetch workers share the cache, the index is only read and written under this lock and the lock file
+var cacheMu sync.Mutex
+
+// lockCacheIndex takes the index lock of this process, then the lock file shared with other runs.
+// The returned func releases both.
+func lockCacheIndex(conf Conf) (func(), error) {
+	cacheMu.Lock()
+
+	err := os.MkdirAll(conf.CachePath, 0755)
+	if err != nil {
+		cacheMu.Unlock()
+		return nil, err
+	}
+
+	unlockFile, err := lockFile(filepath.Join(conf.CachePath, CACHE_INDEX_LOCK))
+	if err != nil {
+		cacheMu.Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile()
+		cacheMu.Unlock()
+	}, nil
+}
+
+// lockFile creates lockPath, waiting while another run holds it
+func lockFile(lockPath string) (func(), error) {
+	for {
+		unlock, ok, err := tryLockFile(lockPath)
+		if err != nil || ok {
+			return unlock, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// tryLockFile creates lockPath unless another run holds it. A held lock is touched so a long clone
+// doesn't look stale, one left untouched for cacheLockStale was left by a run that crashed.
+func tryLockFile(lockPath string) (func(), bool, error) {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		info, err := os.Stat(lockPath)
+		if err == nil && time.Since(info.ModTime()) > cacheLockStale {
+			log.Warn().Msgf("Removing stale lock %v", lockPath)
+			os.Remove(lockPath)
+			return tryLockFile(lockPath)
+		}
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	f.Close()
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(cacheLockStale / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(lockPath, now, now)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		os.Remove(lockPath)
+	}, true, nil
+}
+
+func urlKey(u string) string {
+	sum := sha256.Sum256([]byte(u))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func readCacheIndex(conf Conf) (*CacheIndex, error) {
+	index := &CacheIndex{}
+
+	data, err := os.ReadFile(filepath.Join(conf.CachePath, CACHE_INDEX))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return index, err
+	}
+
+	err = json.Unmarshal(data, index)
+	if err != nil {
+		return index, fmt.Errorf("error reading cache index: %w", err)
+	}
+
+	return index, nil
+}
+
+func writeCacheIndex(conf Conf, index *CacheIndex) error {
+	err := os.MkdirAll(conf.CachePath, 0755)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// a run reading the index never sees it half written
+	tmp, err := os.CreateTemp(conf.CachePath, CACHE_INDEX+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(conf.CachePath, CACHE_INDEX))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// ListCache returns the cached artifacts, most recently used first
+func ListCache(conf Conf) ([]CacheItem, error) {
+	unlock, err := lockCacheIndex(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	index, err := readCacheIndex(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(index.Items, func(i, j int) bool {
+		return index.Items[i].LastUsed.After(index.Items[j].LastUsed)
+	})
+
+	return index.Items, nil
+}
+
+// FindCached looks up a cached artifact of kind for url. An empty revision matches the most recently used one.
+// The returned item's last used time is bumped.
+func FindCached(conf Conf, kind string, url string, revision string) (*CacheItem, error) {
+	if conf.CachePath == "" {
+		return nil, nil
+	}
+
+	unlock, err := lockCacheIndex(conf)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	index, err := readCacheIndex(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	found := -1
+	for i, item := range index.Items {
+		if item.Kind != kind || item.URL != url {
+			continue
+		}
+		if revision != "" && item.Revision != revision && "sha256:"+item.SHA256 != revision {
+			continue
+		}
+
+		exists, _ := util.FileExists(filepath.Join(conf.CachePath, item.Path))
+		if !exists {
+			continue
+		}
+
+		if found == -1 || item.LastUsed.After(index.Items[found].LastUsed) {
+			found = i
+		}
+	}
+
+	if found == -1 {
+		return nil, nil
+	}
+
+	index.Items[found].LastUsed = time.Now().UTC()
+	err = writeCacheIndex(conf, index)
+	if err != nil {
+		return nil, err
+	}
+
+	item := index.Items[found]
+	return &item, nil
+}
+
+// CachedPath is the absolute path of a cached artifact
+func (conf Conf) CachedPath(item CacheItem) string {
+	return filepath.Join(conf.CachePath, item.Path)
+}
+
+// StoreArchive copies a downloaded archive into the cache, content addressed by its sha256
+func StoreArchive(conf Conf, entry AddonEntry, archivePath string, etag string, format util.ArchiveFormat) error {
+	if conf.CachePath == "" {
+		return nil
+	}
+
+	rel := filepath.Join("archives", urlKey(entry.SourceKey()), entry.SHA256+"."+string(format))
+	dest := filepath.Join(conf.CachePath, rel)
+
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	size, err := copyFile(dest, archivePath)
+	if err != nil {
+		return err
+	}
+
+	return addCacheItem(conf, CacheItem{
+		Kind:        CacheArchive,
+		URL:         entry.SourceKey(),
+		Revision:    entry.Revision,
+		ETag:        etag,
+		SHA256:      entry.SHA256,
+		ResolvedURL: entry.ResolvedURL,
+		Format:      string(format),
+		Path:        rel,
+		Size:        size,
+	})
+}
+
+func addCacheItem(conf Conf, item CacheItem) error {
+	unlock, err := lockCacheIndex(conf)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := readCacheIndex(conf)
+	if err != nil {
+		return err
+	}
+
+	item.LastUsed = time.Now().UTC()
+	items := []CacheItem{item}
+	for _, existing := range index.Items {
+		if existing.Path != item.Path {
+			items = append(items, existing)
+		}
+	}
+	index.Items = items
+
+	log.Debug().Msgf("Cached %v %v at %v", item.Kind, item.URL, item.Path)
+	return writeCacheIndex(conf, index)
+}
+
+// GCCache evicts the least recently used artifacts until the cache is at most maxSize bytes,
+// and removes files the index does not know about, once they are an hour old. It returns the bytes freed.
+func GCCache(conf Conf, maxSize int64) (int64, error) {
+	unlock, err := lockCacheIndex(conf)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	index, err := readCacheIndex(conf)
+	if err != nil {
+		return 0, err
+	}
+
+	freed := int64(0)
+
+	// drop index entries whose files are gone, and files no index entry points at
+	known := map[string]bool{}
+	items := []CacheItem{}
+	for _, item := range index.Items {
+		exists, _ := util.FileExists(filepath.Join(conf.CachePath, item.Path))
+		if exists {
+			items = append(items, item)
+			known[item.Path] = true
+		}
+	}
+
+	// archives are keyed by url then hash, git mirrors only by url
+	for _, pattern := range []string{filepath.Join("archives", "*", "*"), filepath.Join("git", "*")} {
+		paths, err := filepath.Glob(filepath.Join(conf.CachePath, pattern))
+		if err != nil {
+			return freed, err
+		}
+		for _, p := range paths {
+			// the lock of a mirror is removed with it, only the leftover of a crash is removed here
+			if mirrorPath, ok := strings.CutSuffix(p, MIRROR_LOCK_EXT); ok {
+				if exists, _ := util.FileExists(mirrorPath); !exists {
+					if unlock, ok, _ := tryLockFile(p); ok {
+						unlock()
+					}
+				}
+				continue
+			}
+
+			rel, err := filepath.Rel(conf.CachePath, p)
+			if err != nil {
+				return freed, err
+			}
+			if known[rel] {
+				continue
+			}
+			if info, err := os.Stat(p); err == nil && time.Since(info.ModTime()) < cacheStoreGrace {
+				continue
+			}
+
+			size, _ := dirSize(p)
+			removed, err := removeCachePath(p)
+			if err != nil {
+				return freed, err
+			}
+			if removed {
+				log.Debug().Msgf("Removed unknown cache path %v", p)
+				freed += size
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].LastUsed.After(items[j].LastUsed)
+	})
+
+	total := int64(0)
+	kept := []CacheItem{}
+	for _, item := range items {
+		if maxSize > 0 && total+item.Size > maxSize {
+			removed, err := removeCachePath(filepath.Join(conf.CachePath, item.Path))
+			if err != nil {
+				return freed, err
+			}
+			if removed {
+				log.Debug().Msgf("Evicted %v %v at %v", item.Kind, item.URL, item.Revision)
+				freed += item.Size
+				continue
+			}
+		}
+
+		total += item.Size
+		kept = append(kept, item)
+	}
+
+	index.Items = kept
+	return freed, writeCacheIndex(conf, index)
+}
+
+// removeCachePath deletes a cached artifact. A git mirror another fetch is using is left alone,
+// removed is false then.
+func removeCachePath(p string) (bool, error) {
+	if filepath.Base(filepath.Dir(p)) == "git" {
+		unlock, ok, err := tryLockMirror(p)
+		if err != nil || !ok {
+			log.Debug().Msgf("Not removing %v, it is in use", p)
+			return false, err
+		}
+		defer unlock()
+	}
+
+	return true, os.RemoveAll(p)
+}
+
+// ParseSize parses a byte size with an optional KB, MB or GB suffix, ex. 500MB
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for _, u := range units {
+		if num, ok := strings.CutSuffix(s, u.suffix); ok {
+			n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid size %q: %w", s, err)
+			}
+			return int64(n * float64(u.mult)), nil
+		}
+	}
+
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func copyFile(dst, src string) (int64, error) {
+	srcF, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer srcF.Close()
+
+	dstF, err := os.Create(dst)
+	if err != nil {
+		return 0, err
+	}
+	defer dstF.Close()
+
+	return io.Copy(dstF, srcF)
+}
+
+func dirSize(path string) (int64, error) {
+	size := int64(0)
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
@@ -0,0 +1,173 @@
+package addons
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheIndexLock(t *testing.T) {
+	conf := Conf{CachePath: t.TempDir()}
+	lockPath := filepath.Join(conf.CachePath, CACHE_INDEX_LOCK)
+
+	// another run holds the lock
+	if err := os.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- addCacheItem(conf, CacheItem{Kind: CacheArchive, URL: "https://example.com/a.zip", Path: "archives/a"})
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("index written while another run held the lock: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	os.Remove(lockPath)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not taken after it was released")
+	}
+
+	// a crashed run left its lock behind
+	if err := os.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * cacheLockStale)
+	if err := os.Chtimes(lockPath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := addCacheItem(conf, CacheItem{Kind: CacheArchive, URL: "https://example.com/b.zip", Path: "archives/b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := readCacheIndex(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Items) != 2 {
+		t.Errorf("got %v index items, want both", len(index.Items))
+	}
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("lock not released: %v", err)
+	}
+}
+
+func TestMirrorLock(t *testing.T) {
+	mirrorPath := filepath.Join(t.TempDir(), "git", "abc.git")
+	if err := os.MkdirAll(filepath.Dir(mirrorPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// another run is fetching into the mirror
+	if err := os.WriteFile(mirrorPath+MIRROR_LOCK_EXT, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := tryLockMirror(mirrorPath); ok || err != nil {
+		t.Fatalf("got the lock of a mirror in use: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		unlock, err := lockMirror(mirrorPath)
+		if err == nil {
+			unlock()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("locked a mirror another run holds: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	os.Remove(mirrorPath + MIRROR_LOCK_EXT)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("lock not taken after it was released")
+	}
+}
+
+func TestGCCacheSkipsMirrorsInUse(t *testing.T) {
+	conf := Conf{CachePath: t.TempDir()}
+	old := time.Now().Add(-2 * cacheStoreGrace)
+
+	mirror := func(name string) string {
+		t.Helper()
+		p := filepath.Join(conf.CachePath, "git", name)
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// unknown to the index but being cloned by another run
+	cloning := mirror("cloning.git")
+	// indexed and over the size limit, but being fetched by another run
+	fetching := mirror("fetching.git")
+	// unknown and unused
+	unused := mirror("unused.git")
+	for _, p := range []string{cloning, fetching} {
+		if err := os.WriteFile(p+MIRROR_LOCK_EXT, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// left by a run that crashed, its mirror is gone
+	crashed := filepath.Join(conf.CachePath, "git", "gone.git"+MIRROR_LOCK_EXT)
+	if err := os.WriteFile(crashed, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(crashed, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addCacheItem(conf, CacheItem{Kind: CacheGit, URL: "https://example.com/a.git", Path: filepath.Join("git", "fetching.git"), Size: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := GCCache(conf, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{cloning, fetching} {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%v in use was removed: %v", filepath.Base(p), err)
+		}
+	}
+	for _, p := range []string{unused, crashed} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%v was not removed: %v", filepath.Base(p), err)
+		}
+	}
+
+	// once the other run is done the mirror is evicted
+	os.Remove(fetching + MIRROR_LOCK_EXT)
+	if _, err := GCCache(conf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fetching); !os.IsNotExist(err) {
+		t.Errorf("fetching.git was not evicted: %v", err)
+	}
+	if _, err := os.Stat(fetching + MIRROR_LOCK_EXT); !os.IsNotExist(err) {
+		t.Errorf("the gc left its lock of fetching.git: %v", err)
+	}
+}
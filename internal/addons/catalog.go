@@ -0,0 +1,272 @@
+package addons
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog/log"
+)
+
+// CatalogAddon is an addon listed in a catalog, a community index of addons. Its source fields are
+// those of a config entry, ex. git = or github =, with metadata to search by.
+type CatalogAddon struct {
+	AddonEntry
+	Author string `json:"author,omitempty"`
+	// Title is the addon's TOC title
+	Title string `json:"title,omitempty"`
+	Notes string `json:"notes,omitempty"`
+	// Catalog is the file or url it was listed in
+	Catalog string `json:"-" toml:"-"`
+}
+
+// Catalog is an index file, JSON {"addons": [...]} or TOML [[addons]] like the config
+type Catalog struct {
+	Addons []CatalogAddon `json:"addons" toml:"addons"`
+}
+
+// Source is where the addon installs from, for listing
+func (a CatalogAddon) Source() string {
+	if a.Url != "" {
+		return a.Url
+	}
+
+	return a.SourceKey()
+}
+
+// LoadCatalogs reads every catalog of the config, files relative to AddOns or urls.
+// Url catalogs are kept in the cache, offline runs and unreachable catalogs use the cached copy.
+func LoadCatalogs(conf Conf) ([]CatalogAddon, error) {
+	addons := []CatalogAddon{}
+	for _, source := range conf.Catalogs {
+		data, err := readCatalog(conf, source)
+		if err != nil {
+			return nil, fmt.Errorf("catalog %v: %w", source, err)
+		}
+
+		catalog := Catalog{}
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+			err = json.Unmarshal(data, &catalog)
+		} else {
+			_, err = toml.Decode(string(data), &catalog)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error parsing catalog %v: %w", source, err)
+		}
+
+		for _, a := range catalog.Addons {
+			if a.Name == "" {
+				log.Warn().Msgf("Skipping an addon without a name in catalog %v", source)
+				continue
+			}
+			// only a catalog on this machine may point at files on it
+			if isURLCatalog(source) && (a.Path != "" || a.Link || localFilePath(a.Url) != "" || localFilePath(a.Zip) != "") {
+				log.Warn().Msgf("Skipping %v in catalog %v, catalogs from urls cannot install local paths", a.Name, source)
+				continue
+			}
+			a.clearResolved()
+			a.Catalog = source
+			a.Title = sanitizeTitle(a.Title)
+			addons = append(addons, a)
+		}
+	}
+
+	return addons, nil
+}
+
+// isURLCatalog is true for catalogs downloaded from a url, false for local files
+func isURLCatalog(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+func readCatalog(conf Conf, source string) ([]byte, error) {
+	if !isURLCatalog(source) {
+		p, err := localPath(source)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(p)
+	}
+
+	cachePath := ""
+	if conf.CachePath != "" {
+		cachePath = filepath.Join(conf.CachePath, "catalogs", urlKey(source)+".index")
+	}
+	if conf.Offline {
+		if cachePath == "" {
+			return nil, fmt.Errorf("offline and the cache is disabled")
+		}
+		return os.ReadFile(cachePath)
+	}
+
+	data, err := downloadCatalog(source)
+	if err != nil {
+		if cachePath == "" {
+			return nil, err
+		}
+		cached, cacheErr := os.ReadFile(cachePath)
+		if cacheErr != nil {
+			return nil, err
+		}
+		log.Warn().Err(err).Msgf("Could not download catalog %v, using the cached copy", source)
+		return cached, nil
+	}
+
+	if cachePath != "" {
+		err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+		if err == nil {
+			err = os.WriteFile(cachePath, data, 0644)
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("could not cache catalog %v", source)
+		}
+	}
+
+	return data, nil
+}
+
+func downloadCatalog(source string) ([]byte, error) {
+	client := http.Client{
+		Timeout: time.Second * 20,
+	}
+
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %v", resp.Status)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// findCatalogAddon looks an addon up by name, ignoring case. Earlier catalogs win.
+func findCatalogAddon(addons []CatalogAddon, name string) *CatalogAddon {
+	for i := range addons {
+		if strings.EqualFold(addons[i].Name, name) {
+			return &addons[i]
+		}
+	}
+
+	return nil
+}
+
+// ResolveCatalogs fills in the source of entries that only have a name, ex. name = "Questie", from the
+// catalogs. Options set on the entry, ex. a branch or exclude, override those of the catalog.
+func (c *Conf) ResolveCatalogs() error {
+	var addons []CatalogAddon
+	for i := range c.Addons {
+		entry := &c.Addons[i]
+		if entry.Name == "" || entry.Url != "" || entry.SourceKey() != "" {
+			continue
+		}
+
+		// catalogs are only read when an entry needs one
+		if addons == nil {
+			if len(c.Catalogs) == 0 {
+				return fmt.Errorf("entry %v has no source, set one or add a catalog listing it", entry.Name)
+			}
+			var err error
+			addons, err = LoadCatalogs(*c)
+			if err != nil {
+				return err
+			}
+		}
+
+		found := findCatalogAddon(addons, entry.Name)
+		if found == nil {
+			return fmt.Errorf("entry %v has no source and is not in any catalog, try wow-addon-cli search %v", entry.Name, entry.Name)
+		}
+
+		keys := []string{}
+		if i < len(c.EntryKeys) {
+			keys = c.EntryKeys[i]
+		}
+		merged := mergeCatalogEntry(found.AddonEntry, *entry, keys)
+		entry.Log().Debug().Msgf("Resolved %v from catalog %v to %v", entry.Name, found.Catalog, found.Source())
+		*entry = merged
+	}
+
+	return nil
+}
+
+// ConfigEntryKeys lists the keys set on each [[addons]] entry of a config, in entry order
+func ConfigEntryKeys(data string) ([][]string, error) {
+	raw := struct {
+		Addons []map[string]any `toml:"addons"`
+	}{}
+	_, err := toml.Decode(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	entryKeys := [][]string{}
+	for _, table := range raw.Addons {
+		keys := []string{}
+		for key := range table {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		entryKeys = append(entryKeys, keys)
+	}
+
+	return entryKeys, nil
+}
+
+// mergeCatalogEntry overrides the catalog's entry with the fields set on the config entry, keys
+// match fields like the config is decoded, ignoring case. A field set to false or "" overrides too.
+func mergeCatalogEntry(catalog AddonEntry, entry AddonEntry, keys []string) AddonEntry {
+	merged := reflect.ValueOf(&catalog).Elem()
+	overrides := reflect.ValueOf(entry)
+	for _, key := range keys {
+		for i := range merged.NumField() {
+			field := merged.Type().Field(i)
+			if strings.EqualFold(field.Name, key) && field.Tag.Get("toml") != "-" {
+				merged.Field(i).Set(overrides.Field(i))
+			}
+		}
+	}
+
+	return catalog
+}
+
+// SearchCatalogs lists the catalog addons whose name, author or TOC title contains term, ignoring case
+func SearchCatalogs(conf Conf, term string) ([]CatalogAddon, error) {
+	if len(conf.Catalogs) == 0 {
+		return nil, fmt.Errorf("no catalogs in the config, add catalogs = [\"https://...\"]")
+	}
+
+	addons, err := LoadCatalogs(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	term = strings.ToLower(term)
+	found := []CatalogAddon{}
+	for _, a := range addons {
+		for _, field := range []string{a.Name, a.Author, a.Title} {
+			if strings.Contains(strings.ToLower(field), term) {
+				found = append(found, a)
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return strings.ToLower(found[i].Name) < strings.ToLower(found[j].Name)
+	})
+
+	return found, nil
+}
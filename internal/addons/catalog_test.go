@@ -0,0 +1,132 @@
+package addons
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+)
+
+const testCatalog = `{"addons": [
+  {"name": "Questie", "github": "Questie/Questie", "asset": "*-classic.zip", "pkgmeta": true},
+  {"name": "MyAddon", "path": "/src/MyAddon", "link": true},
+  {"name": "Remote", "url": "file:///src/Remote.zip"}
+]}`
+
+func TestResolveCatalogs(t *testing.T) {
+	catalogPath := filepath.Join(t.TempDir(), "catalog.json")
+	if err := os.WriteFile(catalogPath, []byte(testCatalog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `
+[[addons]]
+name = "questie"
+tag = "v9.0.0"
+asset = ""
+pkgmeta = false
+
+[[addons]]
+name = "MyAddon"
+link = false
+`
+	conf := Conf{Catalogs: []string{catalogPath}}
+	if _, err := toml.Decode(config, &conf); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ConfigEntryKeys(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.EntryKeys = keys
+
+	if err := conf.ResolveCatalogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	questie := conf.Addons[0]
+	if questie.GitHub != "Questie/Questie" || questie.Tag != "v9.0.0" {
+		t.Errorf("catalog source and config tag not merged: %+v", questie)
+	}
+	if questie.Asset != "" {
+		t.Errorf("asset = \"\" should clear the catalog's asset, got %q", questie.Asset)
+	}
+	if questie.Pkgmeta == nil || *questie.Pkgmeta {
+		t.Errorf("pkgmeta = false should override the catalog, got %v", questie.Pkgmeta)
+	}
+	if questie.UniqueName != "" {
+		t.Errorf("unique name set before hydrating: %q", questie.UniqueName)
+	}
+
+	myAddon := conf.Addons[1]
+	if myAddon.Path != "/src/MyAddon" || myAddon.Link {
+		t.Errorf("link = false should override the catalog's link: %+v", myAddon)
+	}
+}
+
+func TestURLCatalogLocalSources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testCatalog))
+	}))
+	defer server.Close()
+
+	addons, err := LoadCatalogs(Conf{Catalogs: []string{server.URL + "/catalog.json"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addons) != 1 || addons[0].Name != "Questie" {
+		t.Errorf("url catalog addons with local sources should be skipped, got %+v", addons)
+	}
+}
+
+func TestRuntimeFieldsNotDecoded(t *testing.T) {
+	catalogPath := filepath.Join(t.TempDir(), "catalog.json")
+	catalog := `{"addons": [
+  {"name": "Questie", "github": "Questie/Questie", "revision": "release:v1", "sha256": "abc", "resolved_url": "https://example.com/Questie.zip", "locked": {"tag": "v1"}}
+]}`
+	if err := os.WriteFile(catalogPath, []byte(catalog), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `
+[[addons]]
+name = "Questie"
+revision = "release:v2"
+
+[[addons]]
+name = "MyAddon"
+git = "https://example.com/MyAddon.git"
+unique_name = "other"
+Revision = "abc"
+sha256 = "abc"
+resolved_tag = "v2"
+
+[addons.locked]
+commit = "abc"
+`
+	conf := Conf{Catalogs: []string{catalogPath}}
+	if _, err := toml.Decode(config, &conf); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ConfigEntryKeys(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.EntryKeys = keys
+
+	if err := conf.ResolveCatalogs(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range conf.Addons {
+		if entry.UniqueName != "" || entry.Revision != "" || entry.ResolvedTag != "" || entry.ResolvedURL != "" || entry.SHA256 != "" || entry.Locked != nil {
+			t.Errorf("runtime fields decoded: %+v", entry)
+		}
+	}
+	if conf.Addons[0].GitHub != "Questie/Questie" {
+		t.Errorf("catalog entry not merged: %+v", conf.Addons[0])
+	}
+}
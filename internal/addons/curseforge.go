@@ -0,0 +1,155 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DefaultCurseForgeAPI = "https://api.curseforge.com"
+
+// the CurseForge game version type of each flavor, files are filtered by it
+var CURSEFORGE_GAME_VERSION_TYPES = map[string]int{
+	FlavorRetail:  517,
+	FlavorVanilla: 67408,
+	FlavorTBC:     73246,
+	FlavorWrath:   73713,
+	FlavorCata:    77522,
+	FlavorMists:   79434,
+}
+
+// the channel of each CurseForge file release type
+var CURSEFORGE_RELEASE_TYPES = map[int]string{
+	1: ChannelStable,
+	2: ChannelBeta,
+	3: ChannelAlpha,
+}
+
+type curseforgeMod struct {
+	Data struct {
+		ID                   int    `json:"id"`
+		Name                 string `json:"name"`
+		AllowModDistribution *bool  `json:"allowModDistribution"`
+	} `json:"data"`
+}
+
+type curseforgeFiles struct {
+	Data []struct {
+		ID                   int       `json:"id"`
+		DisplayName          string    `json:"displayName"`
+		FileName             string    `json:"fileName"`
+		ReleaseType          int       `json:"releaseType"`
+		FileDate             time.Time `json:"fileDate"`
+		DownloadURL          string    `json:"downloadUrl"`
+		IsAvailable          bool      `json:"isAvailable"`
+		SortableGameVersions []struct {
+			GameVersionTypeID int `json:"gameVersionTypeId"`
+		} `json:"sortableGameVersions"`
+	} `json:"data"`
+	Pagination struct {
+		Index       int `json:"index"`
+		ResultCount int `json:"resultCount"`
+		TotalCount  int `json:"totalCount"`
+	} `json:"pagination"`
+}
+
+// files are listed in pages, the API serves at most the first 10000 results
+const (
+	curseforgePageSize   = 50
+	curseforgeMaxResults = 10000
+)
+
+func (c Conf) curseforgeAPI() string {
+	if c.CurseForgeAPI != "" {
+		return strings.TrimSuffix(c.CurseForgeAPI, "/")
+	}
+
+	return DefaultCurseForgeAPI
+}
+
+// listCurseForgeReleases lists the files of the entry's project for the entry's flavor, newest first. Each file
+// is a release in the channel of its release type, named by its display name and identified by its file id.
+// Projects whose authors disallow third party downloads are refused.
+func listCurseForgeReleases(ctx context.Context, conf Conf, entry AddonEntry) ([]Release, error) {
+	key := conf.CurseForgeKey
+	if key == "" {
+		key = os.Getenv("CURSEFORGE_API_KEY")
+	}
+	if key == "" {
+		return nil, fmt.Errorf("%v needs a CurseForge API key, set curseforgekey in the config or CURSEFORGE_API_KEY", entry.SourceKey())
+	}
+	headers := map[string]string{"x-api-key": key}
+
+	mod := curseforgeMod{}
+	err := getJSON(ctx, fmt.Sprintf("%s/v1/mods/%s", conf.curseforgeAPI(), entry.CurseForge), headers, &mod)
+	if err != nil {
+		return nil, err
+	}
+	if mod.Data.AllowModDistribution != nil && !*mod.Data.AllowModDistribution {
+		return nil, fmt.Errorf("%v (%v): its author disallows downloads outside the CurseForge app, install it from another source", entry.SourceKey(), mod.Data.Name)
+	}
+
+	flavor, err := conf.entryFlavor(entry)
+	if err != nil {
+		return nil, err
+	}
+	filter := ""
+	if flavor != "" {
+		filter = fmt.Sprintf("&gameVersionTypeId=%d", CURSEFORGE_GAME_VERSION_TYPES[flavor])
+	}
+
+	// the order of files is not documented, every page is read so the newest file is not missed
+	files := curseforgeFiles{}
+	for index := 0; index < curseforgeMaxResults; {
+		page := curseforgeFiles{}
+		apiURL := fmt.Sprintf("%s/v1/mods/%s/files?index=%d&pageSize=%d%s", conf.curseforgeAPI(), entry.CurseForge, index, min(curseforgePageSize, curseforgeMaxResults-index), filter)
+		err = getJSON(ctx, apiURL, headers, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		files.Data = append(files.Data, page.Data...)
+		index += len(page.Data)
+		if len(page.Data) == 0 || index >= page.Pagination.TotalCount {
+			break
+		}
+	}
+	sort.SliceStable(files.Data, func(i, j int) bool {
+		return files.Data[i].FileDate.After(files.Data[j].FileDate)
+	})
+
+	releases := []Release{}
+	for _, file := range files.Data {
+		if !file.IsAvailable {
+			continue
+		}
+
+		asset := ReleaseAsset{
+			Name: file.FileName,
+			URL:  file.DownloadURL,
+		}
+		for _, gv := range file.SortableGameVersions {
+			for f, typeID := range CURSEFORGE_GAME_VERSION_TYPES {
+				if gv.GameVersionTypeID == typeID && !slices.Contains(asset.Flavors, f) {
+					asset.Flavors = append(asset.Flavors, f)
+				}
+			}
+		}
+
+		// display names are not unique, the file id tells files apart
+		releases = append(releases, Release{
+			Tag:       file.DisplayName,
+			ID:        strconv.Itoa(file.ID),
+			Channel:   CURSEFORGE_RELEASE_TYPES[file.ReleaseType],
+			Published: file.FileDate,
+			Assets:    []ReleaseAsset{asset},
+		})
+	}
+
+	return releases, nil
+}
@@ -0,0 +1,74 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// curseforgeServer stands in for the CurseForge API, it serves files of project 100 oldest first, one per page
+func curseforgeServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	files := []string{
+		`{"id": 1, "displayName": "Addon 1.0", "fileName": "Addon-1.0.zip", "releaseType": 1, "fileDate": "2024-01-01T00:00:00Z", "downloadUrl": "%[1]s/dl/1", "isAvailable": true}`,
+		`{"id": 2, "displayName": "Addon 1.1", "fileName": "Addon.zip", "releaseType": 1, "fileDate": "2024-02-01T00:00:00Z", "downloadUrl": "%[1]s/dl/2", "isAvailable": true}`,
+		`{"id": 3, "displayName": "Addon 1.1", "fileName": "Addon.zip", "releaseType": 1, "fileDate": "2024-03-01T00:00:00Z", "downloadUrl": "%[1]s/dl/3", "isAvailable": true}`,
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/mods/100":
+			w.Write([]byte(`{"data": {"id": 100, "name": "Addon", "allowModDistribution": true}}`))
+		case "/v1/mods/100/files":
+			index, _ := strconv.Atoi(r.URL.Query().Get("index"))
+			data := ""
+			if index < len(files) {
+				data = strings.ReplaceAll(files[index], "%[1]s", server.URL)
+			}
+			fmt.Fprintf(w, `{"data": [%s], "pagination": {"index": %d, "pageSize": 1, "resultCount": 1, "totalCount": %d}}`, data, index, len(files))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestResolveCurseForgeRelease(t *testing.T) {
+	server := curseforgeServer(t)
+
+	tests := []struct {
+		name     string
+		entry    AddonEntry
+		revision string
+		zip      string
+	}{
+		{name: "newest file on the last page", revision: "release:3@2024-03-01T00:00:00Z/Addon.zip", zip: "/dl/3"},
+		{name: "pinned by file id", entry: AddonEntry{Tag: "2"}, revision: "release:2@2024-02-01T00:00:00Z/Addon.zip", zip: "/dl/2"},
+		{name: "pinned by display name", entry: AddonEntry{Tag: "Addon 1.0"}, revision: "release:1@2024-01-01T00:00:00Z/Addon-1.0.zip", zip: "/dl/1"},
+		{name: "locked file id", entry: AddonEntry{Locked: &LockEntry{Tag: "2"}}, revision: "release:2@2024-02-01T00:00:00Z/Addon.zip", zip: "/dl/2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := Conf{CurseForgeAPI: server.URL, CurseForgeKey: "key"}
+			entry := test.entry
+			entry.CurseForge = "100"
+
+			err := resolveRelease(context.Background(), conf, &entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Revision != test.revision || entry.Zip != server.URL+test.zip {
+				t.Errorf("got revision %v zip %v, want %v %v", entry.Revision, entry.Zip, test.revision, test.zip)
+			}
+		})
+	}
+}
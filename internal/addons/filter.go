@@ -0,0 +1,74 @@
+package addons
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// SkippedInstall is an addon dir of an entry's download that include/exclude left out
+type SkippedInstall struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// validateFilters checks the include and exclude patterns can be matched
+func (entry AddonEntry) validateFilters() error {
+	for _, pattern := range append(append([]string{}, entry.Include...), entry.Exclude...) {
+		_, err := filepath.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("entry %v: bad include/exclude pattern %q: %w", entry.SourceKey(), pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// filterInstalls keeps the addon dirs matched by the entry's include patterns, if any, and not by its exclude patterns
+func filterInstalls(entry AddonEntry, installs []AddonInstall) ([]AddonInstall, []SkippedInstall, error) {
+	kept := []AddonInstall{}
+	skipped := []SkippedInstall{}
+
+	for _, inst := range installs {
+		reason := ""
+		if len(entry.Include) > 0 && matchAny(entry.Include, inst.Name) == "" {
+			reason = "not included"
+		}
+		if pattern := matchAny(entry.Exclude, inst.Name); pattern != "" {
+			reason = "excluded by " + pattern
+		}
+
+		if reason != "" {
+			entry.Log().Info().Msgf("Skipping %v, %v", inst.Name, reason)
+			skipped = append(skipped, SkippedInstall{Name: inst.Name, Reason: reason})
+			continue
+		}
+		kept = append(kept, inst)
+	}
+
+	if len(kept) == 0 && len(installs) > 0 {
+		return kept, skipped, fmt.Errorf("entry %v: include/exclude leave none of its addons %v", entry.SourceKey(), installNames(installs))
+	}
+
+	return kept, skipped, nil
+}
+
+// matchAny returns the first pattern that matches name
+func matchAny(patterns []string, name string) string {
+	for _, pattern := range patterns {
+		ok, _ := filepath.Match(pattern, name)
+		if ok {
+			return pattern
+		}
+	}
+
+	return ""
+}
+
+func installNames(installs []AddonInstall) []string {
+	names := []string{}
+	for _, inst := range installs {
+		names = append(names, inst.Name)
+	}
+
+	return names
+}
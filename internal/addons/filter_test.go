@@ -0,0 +1,88 @@
+package addons
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFilterInstalls(t *testing.T) {
+	installs := []AddonInstall{{Name: "DBM-Core"}, {Name: "DBM-GUI"}, {Name: "DBM-Raids-WoTLK"}, {Name: "DBM-StatusBarTimers"}}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		kept    []string
+		skipped []SkippedInstall
+		err     string
+	}{
+		{name: "no filters", kept: []string{"DBM-Core", "DBM-GUI", "DBM-Raids-WoTLK", "DBM-StatusBarTimers"}},
+		{
+			name:    "include",
+			include: []string{"DBM-Core", "DBM-Raids-*"},
+			kept:    []string{"DBM-Core", "DBM-Raids-WoTLK"},
+			skipped: []SkippedInstall{{Name: "DBM-GUI", Reason: "not included"}, {Name: "DBM-StatusBarTimers", Reason: "not included"}},
+		},
+		{
+			name:    "exclude",
+			exclude: []string{"DBM-GUI", "*Timers"},
+			kept:    []string{"DBM-Core", "DBM-Raids-WoTLK"},
+			skipped: []SkippedInstall{{Name: "DBM-GUI", Reason: "excluded by DBM-GUI"}, {Name: "DBM-StatusBarTimers", Reason: "excluded by *Timers"}},
+		},
+		{
+			name:    "exclude names the first matching pattern",
+			exclude: []string{"DBM-Raids-*", "*-WoTLK"},
+			kept:    []string{"DBM-Core", "DBM-GUI", "DBM-StatusBarTimers"},
+			skipped: []SkippedInstall{{Name: "DBM-Raids-WoTLK", Reason: "excluded by DBM-Raids-*"}},
+		},
+		{
+			name:    "exclude over include",
+			include: []string{"DBM-*"},
+			exclude: []string{"DBM-GUI"},
+			kept:    []string{"DBM-Core", "DBM-Raids-WoTLK", "DBM-StatusBarTimers"},
+			skipped: []SkippedInstall{{Name: "DBM-GUI", Reason: "excluded by DBM-GUI"}},
+		},
+		{
+			name:    "excluded but not included",
+			include: []string{"DBM-Core"},
+			exclude: []string{"DBM-G*"},
+			kept:    []string{"DBM-Core"},
+			skipped: []SkippedInstall{{Name: "DBM-GUI", Reason: "excluded by DBM-G*"}, {Name: "DBM-Raids-WoTLK", Reason: "not included"}, {Name: "DBM-StatusBarTimers", Reason: "not included"}},
+		},
+		{name: "include nothing", include: []string{"Questie"}, err: "include/exclude leave none of its addons"},
+		{name: "exclude everything", exclude: []string{"*"}, err: "include/exclude leave none of its addons"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := AddonEntry{Git: "https://example.com/DBM.git", Include: test.include, Exclude: test.exclude}
+			kept, skipped, err := filterInstalls(entry, slices.Clone(installs))
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v error %v, want %q", installNames(kept), err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(installNames(kept), test.kept) {
+				t.Errorf("kept %v, want %v", installNames(kept), test.kept)
+			}
+			if !slices.Equal(skipped, test.skipped) {
+				t.Errorf("skipped %v, want %v", skipped, test.skipped)
+			}
+		})
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	if err := (AddonEntry{Include: []string{"DBM-*"}, Exclude: []string{"DBM-[GS]*"}}).validateFilters(); err != nil {
+		t.Errorf("good patterns refused: %v", err)
+	}
+	err := AddonEntry{Git: "https://example.com/DBM.git", Exclude: []string{"DBM-[GUI"}}.validateFilters()
+	if err == nil || !strings.Contains(err.Error(), "bad include/exclude pattern") {
+		t.Errorf("got %v, want the bad pattern refused", err)
+	}
+}
@@ -0,0 +1,85 @@
+package addons
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+)
+
+// game client flavors, an entry or the config picks one to select release files
+const (
+	FlavorRetail  = "retail"
+	FlavorVanilla = "vanilla"
+	FlavorTBC     = "tbc"
+	FlavorWrath   = "wrath"
+	FlavorCata    = "cata"
+	FlavorMists   = "mists"
+)
+
+// the words addon authors use for each flavor in file names, ex. Bagnon-10.2.3-wrath.zip
+var FLAVOR_ALIASES = map[string][]string{
+	FlavorRetail:  {"retail", "mainline"},
+	FlavorVanilla: {"vanilla", "classic", "era", "classic_era", "classicera"},
+	FlavorTBC:     {"tbc", "bcc", "bc"},
+	FlavorWrath:   {"wrath", "wotlk", "wotlkc", "wrathc"},
+	FlavorCata:    {"cata", "cataclysm"},
+	FlavorMists:   {"mists", "mop"},
+}
+
+// ParseFlavor normalizes a flavor or one of its aliases, ex. wotlk -> wrath
+func ParseFlavor(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for flavor, aliases := range FLAVOR_ALIASES {
+		if s == flavor || slices.Contains(aliases, s) {
+			return flavor, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown flavor %q, use retail, vanilla, tbc, wrath, cata or mists", s)
+}
+
+// interfaceFlavor is the flavor of a game version, ex. 3.4.3 -> wrath. Every expansion after Mists is retail.
+func interfaceFlavor(version string) string {
+	major, _, _ := strings.Cut(strings.TrimSpace(version), ".")
+	switch major {
+	case "":
+		return ""
+	case "1":
+		return FlavorVanilla
+	case "2":
+		return FlavorTBC
+	case "3":
+		return FlavorWrath
+	case "4":
+		return FlavorCata
+	case "5":
+		return FlavorMists
+	}
+
+	return FlavorRetail
+}
+
+// nameFlavors are the flavors a file name mentions, ex. Bagnon-10.2.3-classic.zip -> [vanilla].
+// A name mentioning classic next to another flavor, ex. wrath-classic, is that other flavor.
+func nameFlavors(name string) []string {
+	words := strings.FieldsFunc(strings.ToLower(util.TrimArchiveExt(name)), func(r rune) bool {
+		return r == '-' || r == '_' || r == '.' || r == ' ' || r == '+'
+	})
+
+	flavors := []string{}
+	for flavor, aliases := range FLAVOR_ALIASES {
+		for _, alias := range aliases {
+			if slices.Contains(words, alias) && !slices.Contains(flavors, flavor) {
+				flavors = append(flavors, flavor)
+			}
+		}
+	}
+
+	if len(flavors) > 1 && slices.Contains(flavors, FlavorVanilla) && slices.Contains(words, "classic") && !slices.Contains(words, "vanilla") && !slices.Contains(words, "era") {
+		flavors = slices.DeleteFunc(flavors, func(f string) bool { return f == FlavorVanilla })
+	}
+
+	return flavors
+}
@@ -0,0 +1,83 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// gitea entries given as owner/repo are on Codeberg unless the config sets another Gitea or Forgejo server
+const DefaultGiteaURL = "https://codeberg.org"
+
+type giteaRelease struct {
+	TagName     string    `json:"tag_name"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+	ZipballURL  string    `json:"zipball_url"`
+	Assets      []struct {
+		Name               string `json:"name"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (c Conf) giteaURL() string {
+	if c.GiteaURL != "" {
+		return strings.TrimSuffix(c.GiteaURL, "/")
+	}
+
+	return DefaultGiteaURL
+}
+
+// giteaAuth is the token header for a server, the token from the config or GITEA_TOKEN is only sent to the config's server
+func (c Conf) giteaAuth(server string) map[string]string {
+	token := c.GiteaToken
+	if token == "" {
+		token = os.Getenv("GITEA_TOKEN")
+	}
+	if token == "" || !sameServer(server, c.giteaURL()) {
+		return map[string]string{}
+	}
+
+	return map[string]string{"Authorization": "token " + token}
+}
+
+// listGiteaReleases lists the published releases of the entry's repo, newest first.
+// Forgejo servers answer the same API.
+func listGiteaReleases(ctx context.Context, conf Conf, entry AddonEntry) ([]Release, error) {
+	server, repo := releaseServer(entry.Gitea, conf.giteaURL())
+
+	gtReleases := []giteaRelease{}
+	apiURL := fmt.Sprintf("%s/api/v1/repos/%s/releases?limit=50", server, repo)
+	err := getJSON(ctx, apiURL, conf.giteaAuth(server), &gtReleases)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []Release{}
+	for _, gtr := range gtReleases {
+		if gtr.Draft {
+			continue
+		}
+
+		release := Release{
+			Tag:        gtr.TagName,
+			Prerelease: gtr.Prerelease,
+			Published:  gtr.PublishedAt,
+		}
+		for _, asset := range gtr.Assets {
+			release.Assets = append(release.Assets, ReleaseAsset{
+				Name: asset.Name,
+				URL:  asset.BrowserDownloadURL,
+			})
+		}
+		if gtr.ZipballURL != "" {
+			release.Source = sourceArchive(repo, gtr.TagName, gtr.ZipballURL)
+		}
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
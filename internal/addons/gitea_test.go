@@ -0,0 +1,120 @@
+package addons
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const giteaReleasesJSON = `[
+  {"tag_name": "v2.1.0-beta1", "prerelease": true, "published_at": "2024-03-01T00:00:00Z",
+   "zipball_url": "%[1]s/owner/Addon/archive/v2.1.0-beta1.zip", "assets": []},
+  {"tag_name": "v3.0.0", "draft": true, "published_at": "2024-04-01T00:00:00Z",
+   "assets": [{"name": "Addon-v3.0.0.zip", "browser_download_url": "%[1]s/owner/Addon/releases/download/v3.0.0/Addon-v3.0.0.zip"}]},
+  {"tag_name": "v2.0.0", "published_at": "2024-02-01T00:00:00Z",
+   "zipball_url": "%[1]s/owner/Addon/archive/v2.0.0.zip",
+   "assets": [{"name": "Addon-v2.0.0.zip", "browser_download_url": "%[1]s/owner/Addon/releases/download/v2.0.0/Addon-v2.0.0.zip"}]}
+]`
+
+// giteaServer stands in for the Gitea API, it records the Authorization header of each request
+func giteaServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	auth := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		if r.URL.Path != "/api/v1/repos/owner/Addon/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.ReplaceAll(giteaReleasesJSON, "%[1]s", server.URL)))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &auth
+}
+
+func TestListGiteaReleases(t *testing.T) {
+	t.Setenv("GITEA_TOKEN", "")
+	server, auth := giteaServer(t)
+
+	tests := []struct {
+		name  string
+		conf  Conf
+		entry AddonEntry
+		auth  string
+	}{
+		{name: "repo path", conf: Conf{GiteaURL: server.URL + "/"}, entry: AddonEntry{Gitea: "owner/Addon"}},
+		{name: "repo path with token", conf: Conf{GiteaURL: server.URL, GiteaToken: "secret"}, entry: AddonEntry{Gitea: "owner/Addon"}, auth: "token secret"},
+		{name: "repo url", entry: AddonEntry{Gitea: server.URL + "/owner/Addon.git"}},
+		// the token is for codeberg.org, not the repo's server
+		{name: "repo url on another server", conf: Conf{GiteaToken: "secret"}, entry: AddonEntry{Gitea: server.URL + "/owner/Addon"}},
+		{name: "repo url on the token's server", conf: Conf{GiteaURL: server.URL, GiteaToken: "secret"}, entry: AddonEntry{Gitea: server.URL + "/owner/Addon/"}, auth: "token secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*auth = nil
+			releases, err := listGiteaReleases(context.Background(), test.conf, test.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags := []string{}
+			for _, release := range releases {
+				tags = append(tags, release.Tag)
+			}
+			if strings.Join(tags, " ") != "v2.1.0-beta1 v2.0.0" {
+				t.Fatalf("got releases %v, the draft should be left out", tags)
+			}
+			if !releases[0].Prerelease || releases[1].Prerelease {
+				t.Errorf("pre-release flags not kept: %+v", releases)
+			}
+			if got := releases[1].Assets; len(got) != 1 || got[0].URL != server.URL+"/owner/Addon/releases/download/v2.0.0/Addon-v2.0.0.zip" {
+				t.Errorf("got assets %+v", got)
+			}
+			if got := releases[1].Source; got == nil || got.Name != "Addon-2.0.0.zip" {
+				t.Errorf("got source archive %+v, want Addon-2.0.0.zip", got)
+			}
+			if (*auth)[0] != test.auth {
+				t.Errorf("got Authorization %q, want %q", (*auth)[0], test.auth)
+			}
+		})
+	}
+}
+
+func TestGiteaAuth(t *testing.T) {
+	t.Setenv("GITEA_TOKEN", "")
+	conf := Conf{GiteaURL: "https://git.example.com", GiteaToken: "secret"}
+
+	tests := []struct {
+		name     string
+		entry    AddonEntry
+		assetURL string
+		auth     string
+	}{
+		{name: "asset on the config's server", entry: AddonEntry{Gitea: "owner/Addon"}, assetURL: "https://git.example.com/owner/Addon/releases/download/v1/Addon.zip", auth: "token secret"},
+		{name: "asset on another server", entry: AddonEntry{Gitea: "owner/Addon"}, assetURL: "https://cdn.example.com/Addon.zip"},
+		{name: "asset over http", entry: AddonEntry{Gitea: "owner/Addon"}, assetURL: "http://git.example.com/Addon.zip"},
+		{name: "repo on another server", entry: AddonEntry{Gitea: "https://codeberg.org/owner/Addon"}, assetURL: "https://codeberg.org/owner/Addon/archive/v1.zip"},
+		{name: "repo url on the config's server", entry: AddonEntry{Gitea: "https://git.example.com/owner/Addon"}, assetURL: "https://git.example.com/Addon.zip", auth: "token secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := conf.releaseAuth(test.entry, test.assetURL)
+			if headers["Authorization"] != test.auth {
+				t.Errorf("got headers %v, want Authorization %q", headers, test.auth)
+			}
+		})
+	}
+
+	t.Setenv("GITEA_TOKEN", "env")
+	headers := Conf{}.giteaAuth(DefaultGiteaURL)
+	if headers["Authorization"] != "token env" {
+		t.Errorf("GITEA_TOKEN not used for codeberg.org, got %v", headers)
+	}
+}
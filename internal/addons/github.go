@@ -0,0 +1,116 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const DefaultGitHubAPI = "https://api.github.com"
+
+type githubRelease struct {
+	TagName     string        `json:"tag_name"`
+	Draft       bool          `json:"draft"`
+	Prerelease  bool          `json:"prerelease"`
+	PublishedAt time.Time     `json:"published_at"`
+	ZipballURL  string        `json:"zipball_url"`
+	Assets      []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name               string `json:"name"`
+	URL                string `json:"url"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// githubAPI is the API base url, GitHub Enterprise servers are at https://<host>/api/v3
+func (c Conf) githubAPI() string {
+	if c.GitHubAPI != "" {
+		return strings.TrimSuffix(c.GitHubAPI, "/")
+	}
+
+	return DefaultGitHubAPI
+}
+
+// githubToken is the token from the config or GITHUB_TOKEN, empty if there is none
+func (c Conf) githubToken() string {
+	if c.GitHubToken != "" {
+		return c.GitHubToken
+	}
+
+	return os.Getenv("GITHUB_TOKEN")
+}
+
+// githubAuth are the token headers for requests to the GitHub API
+func (c Conf) githubAuth() map[string]string {
+	token := c.githubToken()
+	if token == "" {
+		return map[string]string{}
+	}
+
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// githubAssetAuth are the headers for downloading from the GitHub API. Asset urls there answer with
+// the asset's JSON unless the binary is asked for, their download redirects leave the token behind.
+func (c Conf) githubAssetAuth(assetURL string) map[string]string {
+	headers := c.githubAuth()
+	u, err := url.Parse(assetURL)
+	if err == nil && strings.Contains(u.Path, "/releases/assets/") {
+		headers["Accept"] = "application/octet-stream"
+	}
+
+	return headers
+}
+
+// listGitHubReleases lists the published releases of the entry's repo, newest first.
+// A token from the config or GITHUB_TOKEN raises the rate limit and gives access to private repos,
+// their assets are then downloaded through the API, browser download links do not take the token.
+func listGitHubReleases(ctx context.Context, conf Conf, entry AddonEntry) ([]Release, error) {
+	headers := map[string]string{
+		"Accept":               "application/vnd.github+json",
+		"X-GitHub-Api-Version": "2022-11-28",
+	}
+	maps.Copy(headers, conf.githubAuth())
+	useAPI := conf.githubToken() != ""
+
+	ghReleases := []githubRelease{}
+	apiURL := fmt.Sprintf("%s/repos/%s/releases?per_page=100", conf.githubAPI(), entry.GitHub)
+	err := getJSON(ctx, apiURL, headers, &ghReleases)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []Release{}
+	for _, ghr := range ghReleases {
+		if ghr.Draft {
+			continue
+		}
+
+		release := Release{
+			Tag:        ghr.TagName,
+			Prerelease: ghr.Prerelease,
+			Published:  ghr.PublishedAt,
+		}
+		for _, asset := range ghr.Assets {
+			assetURL := asset.BrowserDownloadURL
+			if useAPI && asset.URL != "" {
+				assetURL = asset.URL
+			}
+			release.Assets = append(release.Assets, ReleaseAsset{
+				Name: asset.Name,
+				URL:  assetURL,
+			})
+		}
+		if ghr.ZipballURL != "" {
+			release.Source = sourceArchive(entry.GitHub, ghr.TagName, ghr.ZipballURL)
+		}
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
@@ -0,0 +1,93 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const DefaultGitLabURL = "https://gitlab.com"
+
+type gitlabRelease struct {
+	TagName         string    `json:"tag_name"`
+	ReleasedAt      time.Time `json:"released_at"`
+	UpcomingRelease bool      `json:"upcoming_release"`
+	Assets          struct {
+		Sources []struct {
+			Format string `json:"format"`
+			URL    string `json:"url"`
+		} `json:"sources"`
+		Links []struct {
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+// gitlabURL is the server of gitlab entries given as project paths, ex. a self-hosted https://git.example.com
+func (c Conf) gitlabURL() string {
+	if c.GitLabURL != "" {
+		return strings.TrimSuffix(c.GitLabURL, "/")
+	}
+
+	return DefaultGitLabURL
+}
+
+// gitlabAuth is the token header for a server, the token from the config or GITLAB_TOKEN is only sent to the config's server
+func (c Conf) gitlabAuth(server string) map[string]string {
+	token := c.GitLabToken
+	if token == "" {
+		token = os.Getenv("GITLAB_TOKEN")
+	}
+	if token == "" || !sameServer(server, c.gitlabURL()) {
+		return map[string]string{}
+	}
+
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// listGitLabReleases lists the releases of the entry's project, newest first. Release links are its assets.
+func listGitLabReleases(ctx context.Context, conf Conf, entry AddonEntry) ([]Release, error) {
+	server, project := releaseServer(entry.GitLab, conf.gitlabURL())
+
+	glReleases := []gitlabRelease{}
+	apiURL := fmt.Sprintf("%s/api/v4/projects/%s/releases?per_page=100", server, url.PathEscape(project))
+	err := getJSON(ctx, apiURL, conf.gitlabAuth(server), &glReleases)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []Release{}
+	for _, glr := range glReleases {
+		if glr.UpcomingRelease {
+			continue
+		}
+
+		release := Release{
+			Tag:       glr.TagName,
+			Published: glr.ReleasedAt,
+		}
+		for _, link := range glr.Assets.Links {
+			assetURL := link.DirectAssetURL
+			if assetURL == "" {
+				assetURL = link.URL
+			}
+			release.Assets = append(release.Assets, ReleaseAsset{
+				Name: link.Name,
+				URL:  assetURL,
+			})
+		}
+		for _, source := range glr.Assets.Sources {
+			if source.Format == "zip" {
+				release.Source = sourceArchive(project, glr.TagName, source.URL)
+			}
+		}
+		releases = append(releases, release)
+	}
+
+	return releases, nil
+}
@@ -0,0 +1,123 @@
+package addons
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const gitlabReleasesJSON = `[
+  {"tag_name": "v3.0.0", "upcoming_release": true, "released_at": "2099-01-01T00:00:00Z",
+   "assets": {"sources": [], "links": []}},
+  {"tag_name": "v2.0.0", "released_at": "2024-02-01T00:00:00Z",
+   "assets": {
+     "sources": [{"format": "tar.gz", "url": "%[1]s/group/sub/Addon/-/archive/v2.0.0/Addon-v2.0.0.tar.gz"},
+                 {"format": "zip", "url": "%[1]s/group/sub/Addon/-/archive/v2.0.0/Addon-v2.0.0.zip"}],
+     "links": [{"name": "Addon-v2.0.0.zip", "url": "%[1]s/group/sub/Addon/-/releases/v2.0.0/downloads/Addon-v2.0.0.zip", "direct_asset_url": "%[1]s/dl/Addon-v2.0.0.zip"},
+               {"name": "Addon-v2.0.0-wrath.zip", "url": "%[1]s/uploads/Addon-v2.0.0-wrath.zip"}]
+   }},
+  {"tag_name": "v1.0.0", "released_at": "2024-01-01T00:00:00Z",
+   "assets": {"sources": [{"format": "zip", "url": "%[1]s/group/sub/Addon/-/archive/v1.0.0/Addon-v1.0.0.zip"}], "links": []}}
+]`
+
+// gitlabServer stands in for the GitLab API, it records the Authorization header of each request
+func gitlabServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	auth := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		// the project path is one escaped path segment
+		if r.URL.EscapedPath() != "/api/v4/projects/group%2Fsub%2FAddon/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.ReplaceAll(gitlabReleasesJSON, "%[1]s", server.URL)))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &auth
+}
+
+func TestListGitLabReleases(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "")
+	server, auth := gitlabServer(t)
+
+	tests := []struct {
+		name  string
+		conf  Conf
+		entry AddonEntry
+		auth  string
+	}{
+		{name: "project path", conf: Conf{GitLabURL: server.URL + "/"}, entry: AddonEntry{GitLab: "group/sub/Addon"}},
+		{name: "project path with token", conf: Conf{GitLabURL: server.URL, GitLabToken: "secret"}, entry: AddonEntry{GitLab: "group/sub/Addon"}, auth: "Bearer secret"},
+		{name: "project url", entry: AddonEntry{GitLab: server.URL + "/group/sub/Addon.git"}},
+		// the token is for gitlab.com, not the project's server
+		{name: "project url on another server", conf: Conf{GitLabToken: "secret"}, entry: AddonEntry{GitLab: server.URL + "/group/sub/Addon/"}},
+		{name: "project url on the token's server", conf: Conf{GitLabURL: server.URL, GitLabToken: "secret"}, entry: AddonEntry{GitLab: server.URL + "/group/sub/Addon"}, auth: "Bearer secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			*auth = nil
+			releases, err := listGitLabReleases(context.Background(), test.conf, test.entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tags := []string{}
+			for _, release := range releases {
+				tags = append(tags, release.Tag)
+			}
+			if strings.Join(tags, " ") != "v2.0.0 v1.0.0" {
+				t.Fatalf("got releases %v, the upcoming release should be left out", tags)
+			}
+			assets := releases[0].Assets
+			if len(assets) != 2 || assets[0].URL != server.URL+"/dl/Addon-v2.0.0.zip" || assets[1].URL != server.URL+"/uploads/Addon-v2.0.0-wrath.zip" {
+				t.Errorf("links should use their direct asset url when set, got %+v", assets)
+			}
+			if got := releases[0].Source; got == nil || got.Name != "Addon-2.0.0.zip" || !strings.HasSuffix(got.URL, ".zip") {
+				t.Errorf("got source archive %+v, want the zip Addon-2.0.0.zip", got)
+			}
+			if (*auth)[0] != test.auth {
+				t.Errorf("got Authorization %q, want %q", (*auth)[0], test.auth)
+			}
+		})
+	}
+}
+
+func TestGitLabAuth(t *testing.T) {
+	t.Setenv("GITLAB_TOKEN", "")
+	conf := Conf{GitLabURL: "https://git.example.com", GitLabToken: "secret"}
+
+	tests := []struct {
+		name     string
+		entry    AddonEntry
+		assetURL string
+		auth     string
+	}{
+		{name: "asset on the config's server", entry: AddonEntry{GitLab: "group/Addon"}, assetURL: "https://git.example.com/group/Addon/-/archive/v1/Addon-v1.zip", auth: "Bearer secret"},
+		{name: "asset on another server", entry: AddonEntry{GitLab: "group/Addon"}, assetURL: "https://cdn.example.com/Addon.zip"},
+		{name: "asset over http", entry: AddonEntry{GitLab: "group/Addon"}, assetURL: "http://git.example.com/Addon.zip"},
+		{name: "project on another server", entry: AddonEntry{GitLab: "https://gitlab.com/group/Addon"}, assetURL: "https://gitlab.com/group/Addon/-/archive/v1/Addon-v1.zip"},
+		{name: "project url on the config's server", entry: AddonEntry{GitLab: "https://GIT.example.com/group/Addon"}, assetURL: "https://git.example.com/Addon.zip", auth: "Bearer secret"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := conf.releaseAuth(test.entry, test.assetURL)
+			if headers["Authorization"] != test.auth {
+				t.Errorf("got headers %v, want Authorization %q", headers, test.auth)
+			}
+		})
+	}
+
+	t.Setenv("GITLAB_TOKEN", "env")
+	headers := Conf{}.gitlabAuth(DefaultGitLabURL)
+	if headers["Authorization"] != "Bearer env" {
+		t.Errorf("GITLAB_TOKEN not used for gitlab.com, got %v", headers)
+	}
+}
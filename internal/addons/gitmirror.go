@@ -0,0 +1,581 @@
+package addons
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v6/plumbing/transport"
+	"github.com/rs/zerolog/log"
+)
+
+// blobRefPrefix names the blobs a partial mirror fetches by hash, the refs are removed once fetched
+const blobRefPrefix = "refs/wow-addon-cli/blobs/"
+
+// MIRROR_LOCK_EXT is added to a mirror's path for its lock file, held by the run using the mirror
+const MIRROR_LOCK_EXT = ".lock"
+
+// entries of the same repo may fetch concurrently, only one may use its mirror at a time
+var mirrorLocks sync.Map
+
+// lockMirror takes the mirror's lock of this process, then its lock file shared with other runs
+// and the cache gc. The returned func releases both.
+func lockMirror(mirrorPath string) (func(), error) {
+	mu, _ := mirrorLocks.LoadOrStore(mirrorPath, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+
+	err := os.MkdirAll(filepath.Dir(mirrorPath), 0755)
+	if err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return nil, err
+	}
+
+	unlockFile, err := lockFile(mirrorPath + MIRROR_LOCK_EXT)
+	if err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return nil, err
+	}
+
+	return func() {
+		unlockFile()
+		mu.(*sync.Mutex).Unlock()
+	}, nil
+}
+
+// tryLockMirror is lockMirror without waiting, ok is false when the mirror is in use
+func tryLockMirror(mirrorPath string) (func(), bool, error) {
+	mu, _ := mirrorLocks.LoadOrStore(mirrorPath, &sync.Mutex{})
+	if !mu.(*sync.Mutex).TryLock() {
+		return nil, false, nil
+	}
+
+	unlockFile, ok, err := tryLockFile(mirrorPath + MIRROR_LOCK_EXT)
+	if err != nil || !ok {
+		mu.(*sync.Mutex).Unlock()
+		return nil, false, err
+	}
+
+	return func() {
+		unlockFile()
+		mu.(*sync.Mutex).Unlock()
+	}, true, nil
+}
+
+// MirrorPath is where the bare mirror of a git url is kept. Without a cache it is
+// a throwaway mirror inside the entry's download dir.
+func (c Conf) MirrorPath(entry AddonEntry) (string, error) {
+	if c.CachePath == "" {
+		downloadUniqueDir, err := c.DownloadUniqueDir(entry)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(downloadUniqueDir, ".mirrors", urlKey(entry.Git)+".git"), nil
+	}
+
+	return filepath.Join(c.CachePath, "git", urlKey(entry.Git)+".git"), nil
+}
+
+// syncMirror opens the bare mirror of the entry's repo and fetches what changed since last time,
+// cloning it when there is no mirror yet. A mirror that can't be opened or is missing objects is re-cloned.
+func syncMirror(ctx context.Context, conf Conf, entry AddonEntry, mirrorPath string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(mirrorPath)
+	if err == nil && isShallowMirror(repo) && !conf.Offline {
+		// fetched for the commits of submodules, the entry needs its branches and tags
+		entry.Log().Debug().Msgf("mirror %v only has submodule commits, cloning all of it", mirrorPath)
+		return cloneMirror(ctx, entry, mirrorPath, entry.Subdir != "")
+	}
+	if err == nil {
+		if conf.Offline {
+			return repo, nil
+		}
+
+		entry.Log().Debug().Msgf("Fetching %v into mirror %v", entry.Git, mirrorPath)
+		err = repo.FetchContext(ctx, &git.FetchOptions{
+			RemoteName: "origin",
+			Force:      true,
+			Tags:       git.AllTags,
+			Progress:   new(strings.Builder),
+			Filter:     mirrorFilter(repo),
+		})
+		if err == nil || errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return repo, nil
+		}
+
+		// a healthy mirror means the remote is the problem, keep the mirror for next time
+		if mirrorHealthy(repo) {
+			return nil, err
+		}
+		entry.Log().Warn().Err(err).Msgf("mirror %v looks corrupted, cloning again", mirrorPath)
+	} else if !errors.Is(err, git.ErrRepositoryNotExists) {
+		entry.Log().Warn().Err(err).Msgf("could not open mirror %v, cloning again", mirrorPath)
+	}
+
+	if conf.Offline {
+		return nil, fmt.Errorf("offline and %v is not in the cache", entry.Git)
+	}
+
+	return cloneMirror(ctx, entry, mirrorPath, entry.Subdir != "")
+}
+
+// cloneMirror clones a fresh mirror. A partial mirror gets every commit and tree but no blobs,
+// exportCommit fetches the files it checks out. Servers without filters get a full mirror.
+func cloneMirror(ctx context.Context, entry AddonEntry, mirrorPath string, partial bool) (*git.Repository, error) {
+	err := os.RemoveAll(mirrorPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.Log().Debug().Msgf("Cloning mirror of %v to %v", entry.Git, mirrorPath)
+	cloneOpts := &git.CloneOptions{
+		URL:      entry.Git,
+		Mirror:   true,
+		Progress: new(strings.Builder),
+	}
+	if partial {
+		cloneOpts.Filter = packp.FilterBlobNone()
+	}
+	repo, err := git.PlainCloneContext(ctx, mirrorPath, cloneOpts)
+	if errors.Is(err, transport.ErrFilterNotSupported) {
+		entry.Log().Debug().Msgf("%v does not support partial clones, cloning all of it", entry.Git)
+		return cloneMirror(ctx, entry, mirrorPath, false)
+	}
+	if err != nil {
+		entry.Log().Debug().Msgf("Progress buffer output: %s", cloneOpts.Progress)
+		os.RemoveAll(mirrorPath)
+		return nil, err
+	}
+
+	if partial {
+		// kept the way git records a partial clone, later fetches use the same filter
+		cfg, err := repo.Config()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Raw.Section("remote").Subsection("origin").
+			SetOption("promisor", "true").
+			SetOption("partialclonefilter", string(cloneOpts.Filter))
+		err = repo.SetConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return repo, nil
+}
+
+// mirrorFilter is the filter a partial mirror was cloned with, empty for a full mirror
+func mirrorFilter(repo *git.Repository) packp.Filter {
+	cfg, err := repo.Config()
+	if err != nil {
+		return ""
+	}
+
+	return packp.Filter(cfg.Raw.Section("remote").Subsection("origin").Option("partialclonefilter"))
+}
+
+// fetchMissingBlobs fetches the blobs a partial mirror left out, by hash. Servers that don't
+// allow wanting objects by hash fail with git.ErrExactSHA1NotSupported.
+func fetchMissingBlobs(ctx context.Context, conf Conf, repo *git.Repository, blobs []plumbing.Hash) error {
+	refSpecs := []config.RefSpec{}
+	seen := map[plumbing.Hash]bool{}
+	for _, hash := range blobs {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		err := repo.Storer.HasEncodedObject(hash)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, plumbing.ErrObjectNotFound) {
+			return err
+		}
+		refSpecs = append(refSpecs, config.RefSpec(hash.String()+":"+blobRefPrefix+hash.String()))
+	}
+
+	if len(refSpecs) == 0 {
+		return nil
+	}
+	if conf.Offline {
+		return fmt.Errorf("offline and %d files are not in the partial mirror", len(refSpecs))
+	}
+
+	log.Debug().Msgf("Fetching %d files into partial mirror", len(refSpecs))
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   refSpecs,
+		Tags:       git.NoTags,
+		Progress:   new(strings.Builder),
+	})
+	for _, refSpec := range refSpecs {
+		repo.Storer.RemoveReference(refSpec.Dst(""))
+	}
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return err
+	}
+
+	return nil
+}
+
+// mirrorHealthy checks the mirror's HEAD commit and its tree can be read
+func mirrorHealthy(repo *git.Repository) bool {
+	head, err := repo.Head()
+	if err != nil {
+		return false
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return false
+	}
+
+	_, err = commit.Tree()
+	return err == nil
+}
+
+// gitlink is a submodule in a tree, the commit of another repo to check out at Path
+type gitlink struct {
+	// Path is slash separated and relative to the repo root
+	Path string
+	Hash plumbing.Hash
+}
+
+// exportCommit writes the files of a commit into dest, like a checkout without the .git dir,
+// and returns the submodules it found. With a subdir only that subtree is written (a sparse checkout),
+// at the same path under dest. A partial mirror fetches just the files written here.
+func exportCommit(ctx context.Context, conf Conf, repo *git.Repository, hash plumbing.Hash, dest string, subdir string) ([]gitlink, error) {
+	links := []gitlink{}
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return links, err
+	}
+
+	root, err := commit.Tree()
+	if err != nil {
+		return links, err
+	}
+
+	tree := root
+	if subdir != "" {
+		tree, err = root.Tree(filepath.ToSlash(subdir))
+		if err != nil {
+			return links, fmt.Errorf("subdir %v not found at %v: %w", subdir, hash, err)
+		}
+		dest = filepath.Join(dest, subdir)
+	}
+
+	files := []object.TreeEntry{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, te, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return links, err
+		}
+
+		switch te.Mode {
+		case filemode.Dir:
+			continue
+		case filemode.Submodule:
+			links = append(links, gitlink{
+				Path: path.Join(filepath.ToSlash(subdir), name),
+				Hash: te.Hash,
+			})
+			continue
+		case filemode.Symlink:
+			// like archives, links are not installed, a link could point outside the export and AddOns
+			log.Debug().Msgf("Skipping symlink %v in commit %v", name, hash)
+			continue
+		}
+
+		te.Name = name
+		files = append(files, te)
+	}
+
+	blobs := []plumbing.Hash{}
+	for _, te := range files {
+		blobs = append(blobs, te.Hash)
+	}
+	// submodule urls are read from the .gitmodules at the repo root
+	if len(links) > 0 {
+		if te, err := root.FindEntry(".gitmodules"); err == nil {
+			blobs = append(blobs, te.Hash)
+		}
+	}
+	err = fetchMissingBlobs(ctx, conf, repo, blobs)
+	if err != nil {
+		return links, fmt.Errorf("commit %v: %w", hash, err)
+	}
+
+	for _, te := range files {
+		blob, err := repo.BlobObject(te.Hash)
+		if err != nil {
+			return links, err
+		}
+
+		// a crafted tree could name entries outside the export
+		filePath, err := util.ArchivePath(dest, te.Name)
+		if err != nil {
+			return links, fmt.Errorf("commit %v: %w", hash, err)
+		}
+		err = writeTreeFile(object.NewFile(te.Name, te.Mode, blob), filePath)
+		if err != nil {
+			return links, err
+		}
+	}
+
+	return links, nil
+}
+
+func writeTreeFile(f *object.File, dest string) error {
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if f.Mode == filemode.Executable {
+		mode = 0755
+	}
+
+	src, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// fetchGit syncs the entry's mirror and checks out the wanted commit into the download unique dir
+func fetchGit(ctx context.Context, conf Conf, entry *AddonEntry, downloadUniqueDir string) error {
+	clonePath := filepath.Join(downloadUniqueDir, entry.CloneSubdirName())
+
+	mirrorPath, err := conf.MirrorPath(*entry)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockMirror(mirrorPath)
+	if err != nil {
+		return err
+	}
+	unlock = sync.OnceFunc(unlock)
+	defer unlock()
+
+	repo, err := syncMirror(ctx, conf, *entry, mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	if entry.Locked != nil {
+		entry.ResolvedTag = entry.Locked.Tag
+	} else if entry.FollowsVersion() {
+		tags, err := mirrorTags(repo)
+		if err != nil {
+			return err
+		}
+		entry.ResolvedTag, err = selectTag(*entry, tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	hash, err := wantedCommit(repo, *entry)
+	if errors.Is(err, plumbing.ErrObjectNotFound) && !conf.Offline {
+		// the mirror may be missing objects, start over once
+		entry.Log().Warn().Err(err).Msgf("could not resolve revision in mirror %v, cloning again", mirrorPath)
+		repo, err = cloneMirror(ctx, *entry, mirrorPath, entry.Subdir != "")
+		if err != nil {
+			return err
+		}
+		hash, err = wantedCommit(repo, *entry)
+	}
+	if err != nil {
+		return err
+	}
+
+	if entry.ResolvedTag != "" {
+		entry.Log().Info().Msgf("Resolved %v of %v to tag %v at %v", entry.GitRef(), entry.Git, entry.ResolvedTag, hash)
+	} else if ref := entry.GitRef(); ref != "" {
+		entry.Log().Info().Msgf("Resolved %v of %v to %v", ref, entry.Git, hash)
+	}
+	entry.Log().Debug().Msgf("Checking out %v of %v to %v", hash, entry.Git, clonePath)
+	links, err := exportCommit(ctx, conf, repo, hash, clonePath, entry.Subdir)
+	if errors.Is(err, git.ErrExactSHA1NotSupported) {
+		entry.Log().Warn().Msgf("%v does not send files by hash, cloning all of it to %v", entry.Git, mirrorPath)
+		repo, err = cloneMirror(ctx, *entry, mirrorPath, false)
+		if err != nil {
+			return err
+		}
+		links, err = exportCommit(ctx, conf, repo, hash, clonePath, entry.Subdir)
+	}
+	if err != nil {
+		return err
+	}
+	entry.Revision = hash.String()
+
+	err = cacheMirror(conf, *entry, repo, mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	if !entry.FetchSubmodules() {
+		if len(links) > 0 {
+			entry.Log().Info().Msgf("Skipping %d submodules of %v", len(links), entry.Git)
+		}
+		links = nil
+	}
+	modules, err := readSubmodules(repo, hash, entry.Git, links)
+	if err != nil {
+		return err
+	}
+
+	// submodules and externals lock their own mirrors, which may be this one
+	unlock()
+
+	err = exportSubmodules(ctx, conf, *entry, modules, entry.Git, clonePath, links, 0)
+	if err != nil {
+		return err
+	}
+
+	// a subdir is picked by hand instead, the .pkgmeta at the repo root is not checked out
+	if !entry.ApplyPkgmeta() || entry.Subdir != "" {
+		return nil
+	}
+
+	return applyPkgmeta(ctx, conf, *entry, clonePath)
+}
+
+// cacheMirror records a mirror in the cache index so it's kept until evicted
+func cacheMirror(conf Conf, entry AddonEntry, repo *git.Repository, mirrorPath string) error {
+	if conf.CachePath == "" {
+		return nil
+	}
+
+	// the cached revision is what the mirror's HEAD was at the last fetch, offline runs install it.
+	// A mirror with only the commits of submodules has no HEAD.
+	revision := ""
+	head, err := repo.Head()
+	if err == nil {
+		revision = head.Hash().String()
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return err
+	}
+	size, err := dirSize(mirrorPath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(conf.CachePath, mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	return addCacheItem(conf, CacheItem{
+		Kind:     CacheGit,
+		URL:      entry.Git,
+		Revision: revision,
+		Path:     rel,
+		Size:     size,
+	})
+}
+
+// wantedCommit resolves the commit to install, the locked commit, the entry's branch, tag or commit pin,
+// the tag its version resolved to, or the mirror's HEAD
+func wantedCommit(repo *git.Repository, entry AddonEntry) (plumbing.Hash, error) {
+	if entry.Locked != nil && entry.Locked.Commit != "" {
+		hash := plumbing.NewHash(entry.Locked.Commit)
+		_, err := repo.CommitObject(hash)
+		if err != nil {
+			return hash, fmt.Errorf("locked commit %v of %v: %w", entry.Locked.Commit, entry.Git, err)
+		}
+		return hash, nil
+	}
+
+	var hash plumbing.Hash
+	switch {
+	case entry.Branch != "":
+		ref, err := repo.Reference(plumbing.NewBranchReferenceName(entry.Branch), true)
+		if err != nil {
+			return hash, fmt.Errorf("branch %v not found in %v: %w", entry.Branch, entry.Git, err)
+		}
+		hash = ref.Hash()
+	case entry.Tag != "" || entry.ResolvedTag != "":
+		name := entry.Tag
+		if name == "" {
+			name = entry.ResolvedTag
+		}
+		ref, err := repo.Reference(plumbing.NewTagReferenceName(name), true)
+		if err != nil {
+			return hash, fmt.Errorf("tag %v not found in %v: %w", name, entry.Git, err)
+		}
+		hash = ref.Hash()
+		// annotated tags point at a tag object
+		tag, err := repo.TagObject(hash)
+		if err == nil {
+			commit, err := tag.Commit()
+			if err != nil {
+				return hash, err
+			}
+			hash = commit.Hash
+		}
+	case entry.Commit != "":
+		resolved, err := repo.ResolveRevision(plumbing.Revision(entry.Commit))
+		if err != nil {
+			return hash, fmt.Errorf("commit %v not found in %v: %w", entry.Commit, entry.Git, err)
+		}
+		hash = *resolved
+	default:
+		head, err := repo.Head()
+		if err != nil {
+			return hash, err
+		}
+		hash = head.Hash()
+	}
+
+	_, err := repo.CommitObject(hash)
+	return hash, err
+}
+
+// mirrorTags lists the tag names in a mirror
+func mirrorTags(repo *git.Repository) ([]string, error) {
+	tags := []string{}
+
+	iter, err := repo.Tags()
+	if err != nil {
+		return tags, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		tags = append(tags, ref.Name().Short())
+		return nil
+	})
+
+	return tags, err
+}
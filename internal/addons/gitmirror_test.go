@@ -0,0 +1,206 @@
+package addons
+
+import (
+	"context"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// commitFiles makes a repo in dir with one commit of files, ex. {"src/Foo/Foo.toc": "..."}.
+// Files whose content starts with -> are symlinks to the rest of it.
+func commitFiles(t *testing.T, dir string, files map[string]string) (*git.Repository, plumbing.Hash) {
+	t.Helper()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if target, ok := strings.CutPrefix(content, "->"); ok {
+			err = os.Symlink(target, path)
+		} else {
+			err = os.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hash, err := wt.Commit("files", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return repo, hash
+}
+
+func TestExportCommit(t *testing.T) {
+	repo, hash := commitFiles(t, t.TempDir(), map[string]string{
+		"src/Foo/Foo.toc": "## Interface: 30300\n",
+		"src/Foo/Libs":    "->/etc",
+		"src/Foo/Up":      "->../../..",
+		"tools/build.sh":  "echo",
+		"README.md":       "readme",
+	})
+
+	dest := t.TempDir()
+	_, err := exportCommit(context.Background(), Conf{}, repo, hash, dest, "src")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dest, "src", "Foo", "Foo.toc")); err != nil {
+		t.Errorf("subdir file not exported: %v", err)
+	}
+	for _, p := range []string{"README.md", "tools", "src/Foo/Libs", "src/Foo/Up"} {
+		if _, err := os.Lstat(filepath.Join(dest, filepath.FromSlash(p))); !os.IsNotExist(err) {
+			t.Errorf("%v should not be exported: %v", p, err)
+		}
+	}
+}
+
+// gitServer serves the repo in dir over smart http with git http-backend, configured with
+// the given uploadpack options, ex. "uploadpack.allowFilter"
+func gitServer(t *testing.T, dir string, options ...string) string {
+	t.Helper()
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not installed")
+	}
+
+	for _, option := range options {
+		out, err := exec.Command(gitPath, "-C", dir, "config", option, "true").CombinedOutput()
+		if err != nil {
+			t.Fatalf("git config %v: %v %s", option, err, out)
+		}
+	}
+
+	server := httptest.NewServer(&cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(dir), "GIT_HTTP_EXPORT_ALL=1"},
+	})
+	t.Cleanup(server.Close)
+
+	return server.URL + "/" + filepath.Base(dir)
+}
+
+func TestPartialMirror(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		partial bool
+	}{
+		{name: "partial clone", options: []string{"uploadpack.allowFilter", "uploadpack.allowAnySHA1InWant"}, partial: true},
+		{name: "server without filters", options: nil},
+		{name: "server without objects by hash", options: []string{"uploadpack.allowFilter"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repoDir := filepath.Join(t.TempDir(), "Addon")
+			src, _ := commitFiles(t, repoDir, map[string]string{
+				"Foo/Foo.toc":    "## Interface: 30300\n",
+				"Bar/Bar.toc":    "## Interface: 30300\n",
+				"media/big.blp":  "pixels",
+				"Foo/Libs/a.lua": "-- lib",
+			})
+			conf := Conf{CachePath: t.TempDir()}
+			entry := AddonEntry{Git: gitServer(t, repoDir, test.options...), Subdir: "Foo"}
+
+			// each fetch checks out the newest commit, the second one fetches into the existing mirror
+			for i, file := range []string{"Foo/Foo.lua", "Foo/Second.lua"} {
+				if i > 0 {
+					if err := os.WriteFile(filepath.Join(repoDir, filepath.FromSlash(file)), []byte("-- code"), 0644); err != nil {
+						t.Fatal(err)
+					}
+					wt, _ := src.Worktree()
+					if _, err := wt.Add(file); err != nil {
+						t.Fatal(err)
+					}
+					_, err := wt.Commit("second", &git.CommitOptions{
+						Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				downloadDir := t.TempDir()
+				fetched := entry
+				err := fetchGit(context.Background(), conf, &fetched, downloadDir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				clonePath := filepath.Join(downloadDir, fetched.CloneSubdirName())
+				for _, name := range []string{"Foo/Foo.toc", "Foo/Libs/a.lua"} {
+					if _, err := os.Stat(filepath.Join(clonePath, filepath.FromSlash(name))); err != nil {
+						t.Errorf("fetch %d: %v not checked out: %v", i, name, err)
+					}
+				}
+				if i > 0 {
+					if _, err := os.Stat(filepath.Join(clonePath, "Foo", "Second.lua")); err != nil {
+						t.Errorf("the second commit was not checked out: %v", err)
+					}
+				}
+			}
+
+			mirrorPath, err := conf.MirrorPath(entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mirror, err := git.PlainOpen(mirrorPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mirrorFilter(mirror) != ""; got != test.partial {
+				t.Errorf("got a partial mirror %v, want %v", got, test.partial)
+			}
+			refs, _ := mirror.References()
+			refs.ForEach(func(ref *plumbing.Reference) error {
+				if strings.HasPrefix(ref.Name().String(), blobRefPrefix) {
+					t.Errorf("left %v in the mirror", ref.Name())
+				}
+				return nil
+			})
+
+			// blobs outside the subdir are only fetched into a full mirror
+			head, _ := src.Head()
+			commit, _ := src.CommitObject(head.Hash())
+			file, err := commit.File("media/big.blp")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = mirror.BlobObject(file.Hash)
+			if test.partial && err == nil {
+				t.Errorf("the partial mirror fetched a file outside the subdir")
+			}
+			if !test.partial && err != nil {
+				t.Errorf("the full mirror is missing a file: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package addons
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+)
+
+// localPath makes a config path absolute. Relative paths are relative to AddOns, ~ is the home dir.
+func localPath(p string) (string, error) {
+	if rest, ok := strings.CutPrefix(p, "~"); ok && (rest == "" || os.IsPathSeparator(rest[0])) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		p = home + rest
+	}
+
+	return filepath.Abs(p)
+}
+
+// hydrateLocal makes an entry's path absolute and checks link is only used where it can be
+func (entry *AddonEntry) hydrateLocal() error {
+	if entry.Path != "" {
+		abs, err := localPath(entry.Path)
+		if err != nil {
+			return fmt.Errorf("entry %v: %w", entry.Path, err)
+		}
+		entry.Path = abs
+	}
+
+	if !entry.Link {
+		return nil
+	}
+	if entry.Path == "" {
+		return fmt.Errorf("entry %v: link only applies to path sources", entry.SourceKey())
+	}
+	if len(entry.Rename) > 0 {
+		return fmt.Errorf("entry %v: a linked working copy cannot be renamed, its TOC files would be renamed too", entry.SourceKey())
+	}
+
+	return nil
+}
+
+// localFilePath is the local path of a file:// url, empty for other urls
+func localFilePath(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+
+	return filepath.FromSlash(u.Path)
+}
+
+// fetchPath copies a local working copy into the download unique dir, or for linked entries only
+// records its revision, their addons are found and linked in the working copy itself
+func fetchPath(entry *AddonEntry, downloadUniqueDir string) error {
+	isDir, err := util.IsDirectory(entry.Path)
+	if err != nil {
+		return err
+	}
+	if !isDir {
+		return fmt.Errorf("path %v is not a directory", entry.Path)
+	}
+
+	entry.Revision, err = localRevision(*entry)
+	if err != nil {
+		return err
+	}
+	if entry.Link {
+		return nil
+	}
+
+	copyDir := filepath.Join(downloadUniqueDir, filepath.Base(entry.Path))
+	err = os.MkdirAll(copyDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	entry.Log().Debug().Msgf("Copying %v to %v", entry.Path, copyDir)
+	return util.CopyDir(copyDir, entry.Path)
+}
+
+// fetchLocalArchive extracts a file:// archive in place, it is not downloaded or cached
+func fetchLocalArchive(entry *AddonEntry, archivePath string, downloadUniqueDir string) error {
+	sha256Hex, err := util.HashFile(archivePath)
+	if err != nil {
+		return err
+	}
+	if entry.Locked != nil && entry.Locked.SHA256 != "" && entry.Locked.SHA256 != sha256Hex {
+		return fmt.Errorf("archive %v has sha256 %v, the lockfile has %v", entry.Zip, sha256Hex, entry.Locked.SHA256)
+	}
+	entry.SHA256 = sha256Hex
+	entry.Revision = archiveRevision("", sha256Hex)
+	entry.ResolvedURL = entry.Zip
+
+	format, err := util.DetectArchiveFormat(archivePath, archivePath)
+	if err != nil {
+		return err
+	}
+
+	entry.Log().Debug().Msgf("Extracting %v archive %v", format, archivePath)
+	return util.Extract(archivePath, format, downloadUniqueDir)
+}
+
+// localRevision is the revision of a local source. A working copy is hashed like CopyDir copies it,
+// dot files left out, so editing it is an update. A linked working copy is always current.
+func localRevision(entry AddonEntry) (string, error) {
+	if entry.Link {
+		return "link:" + entry.Path, nil
+	}
+
+	if p := localFilePath(entry.Zip); p != "" {
+		sha256Hex, err := util.HashFile(p)
+		return archiveRevision("", sha256Hex), err
+	}
+
+	lines := []string{}
+	err := filepath.WalkDir(entry.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != entry.Path && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(entry.Path, path)
+		if err != nil {
+			return err
+		}
+		fileHash, err := util.HashFile(path)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, filepath.ToSlash(rel)+" "+fileHash)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// IsLocal is true for entries installed from the local filesystem
+func (entry AddonEntry) IsLocal() bool {
+	return entry.Path != "" || localFilePath(entry.Zip) != ""
+}
@@ -0,0 +1,104 @@
+package addons
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeZip writes an archive of Foo/Foo.toc with the given content
+func writeZip(t *testing.T, path, content string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	toc, err := w.Create("Foo/Foo.toc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := toc.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstallLocalSources(t *testing.T) {
+	v1 := "## Interface: 30300\n## Title: Foo\n"
+	v2 := v1 + "## Version: 2\n"
+
+	tests := []struct {
+		name string
+		// source writes the entry's source with content, it is called again to edit it
+		source func(t *testing.T, dir, content string) AddonEntry
+		// kind is the action once the source was edited, content what AddOns/Foo has then
+		kind ActionKind
+	}{
+		{
+			name: "path",
+			source: func(t *testing.T, dir, content string) AddonEntry {
+				writeAddon(t, filepath.Join(dir, "Foo"), content)
+				return AddonEntry{Name: "local", Path: dir}
+			},
+			kind: ActionUpdate,
+		},
+		{
+			name: "link",
+			source: func(t *testing.T, dir, content string) AddonEntry {
+				writeAddon(t, filepath.Join(dir, "Foo"), content)
+				return AddonEntry{Name: "local", Path: dir, Link: true}
+			},
+			// a link is always current
+			kind: ActionUnchanged,
+		},
+		{
+			name: "file url",
+			source: func(t *testing.T, dir, content string) AddonEntry {
+				archive := filepath.Join(dir, "Foo.zip")
+				writeZip(t, archive, content)
+				return AddonEntry{Name: "local", Zip: "file://" + filepath.ToSlash(archive)}
+			},
+			kind: ActionUpdate,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			conf := testConf(t, test.source(t, dir, v1))
+			dest := filepath.Join(conf.AddonsPath, "Foo")
+
+			if kinds := install(t, conf); kinds["local"] != ActionAdd {
+				t.Fatalf("first run: %v", kinds)
+			}
+			if got := readAddon(t, dest); got != v1 {
+				t.Errorf("installed %q, want %q", got, v1)
+			}
+			marker, err := ReadMarker(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if marker.Source != conf.Addons[0].SourceKey() {
+				t.Errorf("got marker source %v, want %v", marker.Source, conf.Addons[0].SourceKey())
+			}
+
+			if kinds := install(t, conf); kinds["local"] != ActionUnchanged {
+				t.Errorf("second run: %v", kinds)
+			}
+
+			test.source(t, dir, v2)
+			if kinds := install(t, conf); kinds["local"] != test.kind {
+				t.Errorf("run after editing the source: %v, want %v", kinds, test.kind)
+			}
+			if got := readAddon(t, dest); got != v2 {
+				t.Errorf("installed %q after editing the source, want %q", got, v2)
+			}
+		})
+	}
+}
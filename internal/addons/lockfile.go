@@ -0,0 +1,186 @@
+package addons
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rs/zerolog/log"
+)
+
+// LockEntry records exactly what a config entry resolved to when it was last installed
+type LockEntry struct {
+	// Source is the entry's SourceKey
+	Source string `toml:"source" json:"source"`
+	// Subdir is the entry's subdir, set for entries sharing a repo
+	Subdir string `toml:"subdir,omitempty" json:"subdir,omitempty"`
+	// Ref is the branch, tag or commit the entry was pinned to, see AddonEntry.GitRef
+	Ref string `toml:"ref,omitempty" json:"ref,omitempty"`
+	// Tag is the tag a version constraint resolved to
+	Tag string `toml:"tag,omitempty" json:"tag,omitempty"`
+	// Commit is the resolved git commit
+	Commit string `toml:"commit,omitempty" json:"commit,omitempty"`
+	// URL is the final archive url after redirects
+	URL string `toml:"url,omitempty" json:"url,omitempty"`
+	// SHA256 is the hash of the downloaded archive
+	SHA256 string `toml:"sha256,omitempty" json:"sha256,omitempty"`
+	// Folders are the addon dirs the entry produced
+	Folders []string `toml:"folders" json:"folders"`
+}
+
+type Lockfile struct {
+	Addons []LockEntry `toml:"addons"`
+}
+
+// LockPathForConfig puts the lockfile next to the config, ex. config.toml -> config.lock
+func LockPathForConfig(configPath string) string {
+	ext := filepath.Ext(configPath)
+	return configPath[:len(configPath)-len(ext)] + ".lock"
+}
+
+// ReadLockfile reads a lockfile, a missing lockfile is empty
+func ReadLockfile(path string) (*Lockfile, error) {
+	lock := &Lockfile{}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lock, nil
+		}
+		return lock, err
+	}
+
+	_, err = toml.Decode(string(data), lock)
+	if err != nil {
+		return lock, fmt.Errorf("error reading lockfile %v: %w", path, err)
+	}
+
+	return lock, nil
+}
+
+// Find looks up the lock of an entry by its source and subdir
+func (lock Lockfile) Find(entry AddonEntry) *LockEntry {
+	for i := range lock.Addons {
+		if entry.ownsSource(lock.Addons[i].Source, lock.Addons[i].Subdir) {
+			return &lock.Addons[i]
+		}
+	}
+
+	return nil
+}
+
+func (lock Lockfile) Write(path string) error {
+	buf := new(bytes.Buffer)
+	buf.WriteString("# Generated by wow-addon-cli, do not edit.\n\n")
+	err := toml.NewEncoder(buf).Encode(lock)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// UpdateLockfile records what each entry of an applied plan resolved to.
+// Unchanged entries were not downloaded, so their previous archive url and hash are kept.
+// Entries that are no longer configured are dropped unless conf.KeepUnlisted is set.
+func UpdateLockfile(conf Conf, plan *Plan) error {
+	if conf.LockPath == "" {
+		return nil
+	}
+
+	prev, err := ReadLockfile(conf.LockPath)
+	if err != nil {
+		return err
+	}
+
+	lock := Lockfile{}
+	// a partial run keeps the locks of entries it did not process
+	if conf.KeepUnlisted {
+		for _, le := range prev.Addons {
+			planned := slices.ContainsFunc(plan.Actions, func(action Action) bool {
+				return action.Entry.ownsSource(le.Source, le.Subdir)
+			})
+			if !planned {
+				lock.Addons = append(lock.Addons, le)
+			}
+		}
+	}
+
+	for _, action := range plan.Actions {
+		if action.Kind == ActionRemove {
+			continue
+		}
+
+		entry := action.Entry
+		le := LockEntry{
+			Source: entry.SourceKey(),
+			Subdir: filepath.ToSlash(entry.Subdir),
+		}
+
+		if old := prev.Find(entry); old != nil {
+			le = *old
+		}
+
+		if entry.Git != "" || entry.IsRelease() {
+			if le.Ref != entry.GitRef() {
+				le.Tag = ""
+			}
+			le.Ref = entry.GitRef()
+		}
+		if entry.Git != "" {
+			le.Commit = entry.Revision
+		}
+		if entry.ResolvedTag != "" {
+			le.Tag = entry.ResolvedTag
+		}
+		if entry.ResolvedURL != "" {
+			le.URL = entry.ResolvedURL
+		}
+		if entry.SHA256 != "" {
+			le.SHA256 = entry.SHA256
+		}
+
+		le.Folders = []string{}
+		if action.Kind == ActionUnchanged {
+			for _, d := range action.Dirs {
+				le.Folders = append(le.Folders, filepath.Base(d))
+			}
+		} else {
+			for _, inst := range action.Installs {
+				le.Folders = append(le.Folders, inst.Name)
+			}
+		}
+		sort.Strings(le.Folders)
+
+		lock.Addons = append(lock.Addons, le)
+	}
+
+	log.Debug().Msgf("Writing lockfile %v", conf.LockPath)
+	return lock.Write(conf.LockPath)
+}
+
+// verifyLockedFolders fails a frozen install when an entry produces different addon dirs than were locked
+func verifyLockedFolders(entry AddonEntry, installs []AddonInstall) error {
+	if entry.Locked == nil {
+		return nil
+	}
+
+	folders := []string{}
+	for _, inst := range installs {
+		folders = append(folders, inst.Name)
+	}
+	sort.Strings(folders)
+
+	locked := slices.Clone(entry.Locked.Folders)
+	sort.Strings(locked)
+
+	if !slices.Equal(folders, locked) {
+		return fmt.Errorf("entry %v produced folders %v, the lockfile has %v", entry.SourceKey(), folders, locked)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package addons
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestUpdateLockfile(t *testing.T) {
+	prev := Lockfile{Addons: []LockEntry{
+		{Source: "https://example.com/Foo.git", Ref: "version:^1", Tag: "v1.2.0", Commit: "aaaa", Folders: []string{"Foo"}},
+		{Source: "https://example.com/Mono.git", Subdir: "src", Commit: "bbbb", Folders: []string{"Mono"}},
+		{Source: "https://example.com/Mono.git", Subdir: "extra", Commit: "bbbb", Folders: []string{"MonoExtra"}},
+	}}
+
+	tests := []struct {
+		name         string
+		keepUnlisted bool
+		entry        AddonEntry
+		want         []LockEntry
+	}{
+		{
+			name:  "unlisted entries are dropped",
+			entry: AddonEntry{Git: "https://example.com/Foo.git", Version: "^1", Revision: "cccc", ResolvedTag: "v1.3.0"},
+			want: []LockEntry{
+				{Source: "https://example.com/Foo.git", Ref: "version:^1", Tag: "v1.3.0", Commit: "cccc", Folders: []string{"Foo"}},
+			},
+		},
+		{
+			name:         "keep unlisted merges the run into the previous locks",
+			keepUnlisted: true,
+			entry:        AddonEntry{Git: "https://example.com/Mono.git", Subdir: "src", Revision: "dddd"},
+			want: []LockEntry{
+				prev.Addons[0],
+				prev.Addons[2],
+				{Source: "https://example.com/Mono.git", Subdir: "src", Commit: "dddd", Folders: []string{"Foo"}},
+			},
+		},
+		{
+			name:  "a new ref drops the resolved tag",
+			entry: AddonEntry{Git: "https://example.com/Foo.git", Branch: "main", Revision: "eeee"},
+			want: []LockEntry{
+				{Source: "https://example.com/Foo.git", Ref: "branch:main", Commit: "eeee", Folders: []string{"Foo"}},
+			},
+		},
+		{
+			name:  "the same ref keeps the resolved tag",
+			entry: AddonEntry{Git: "https://example.com/Foo.git", Version: "^1", Revision: "aaaa"},
+			want: []LockEntry{
+				{Source: "https://example.com/Foo.git", Ref: "version:^1", Tag: "v1.2.0", Commit: "aaaa", Folders: []string{"Foo"}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := testConf(t)
+			conf.KeepUnlisted = test.keepUnlisted
+			if err := prev.Write(conf.LockPath); err != nil {
+				t.Fatal(err)
+			}
+
+			plan := &Plan{Actions: []Action{{
+				Kind:     ActionUpdate,
+				Entry:    test.entry,
+				Installs: []AddonInstall{{Name: "Foo"}},
+			}}}
+			if err := UpdateLockfile(conf, plan); err != nil {
+				t.Fatal(err)
+			}
+
+			lock, err := ReadLockfile(conf.LockPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lock.Addons) != len(test.want) {
+				t.Fatalf("got locks %+v, want %+v", lock.Addons, test.want)
+			}
+			for i := range test.want {
+				got, want := lock.Addons[i], test.want[i]
+				if got.Source != want.Source || got.Subdir != want.Subdir || got.Ref != want.Ref || got.Tag != want.Tag ||
+					got.Commit != want.Commit || strings.Join(got.Folders, " ") != strings.Join(want.Folders, " ") {
+					t.Errorf("lock %d: got %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFrozenInstall(t *testing.T) {
+	repoDir := t.TempDir()
+	_, hash := commitFiles(t, repoDir, map[string]string{
+		"Foo/Foo.toc": "## Interface: 30300\n## Title: Foo\n",
+	})
+	source := AddonEntry{Git: repoDir}.SourceKey()
+
+	tests := []struct {
+		name  string
+		entry AddonEntry
+		lock  []LockEntry
+		err   string
+	}{
+		{
+			name: "locked",
+			lock: []LockEntry{{Source: source, Commit: hash.String(), Folders: []string{"Foo"}}},
+		},
+		{
+			name: "not in the lockfile",
+			err:  "is not in lockfile",
+		},
+		{
+			name:  "ref mismatch",
+			entry: AddonEntry{Tag: "v1.0.0"},
+			lock:  []LockEntry{{Source: source, Commit: hash.String(), Folders: []string{"Foo"}}},
+			err:   `is locked at "" and the config pins "tag:v1.0.0"`,
+		},
+		{
+			name: "revision mismatch",
+			lock: []LockEntry{{Source: source, Commit: strings.Repeat("1", 40), Folders: []string{"Foo"}}},
+			err:  "locked commit 1111111111111111111111111111111111111111",
+		},
+		{
+			name: "folder mismatch",
+			lock: []LockEntry{{Source: source, Commit: hash.String(), Folders: []string{"Bar"}}},
+			err:  "produced folders [Foo], the lockfile has [Bar]",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := test.entry
+			entry.Name = "foo"
+			entry.Git = repoDir
+			conf := testConf(t, entry)
+			conf.Frozen = true
+			if err := (Lockfile{Addons: test.lock}).Write(conf.LockPath); err != nil {
+				t.Fatal(err)
+			}
+
+			actions, err := Reconcile(context.Background(), conf)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(actions) != 1 || actions[0].Entry.Revision != hash.String() {
+				t.Errorf("got actions %+v, want the locked commit %v", actions, hash)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package addons
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// Version of the tool, set at build time
+var Version = "dev"
+
+// Marker is the install manifest in the MARKER file of each installed addon dir.
+// Markers written by older versions are empty.
+type Marker struct {
+	// Source identifies the config entry that installed the dir, see AddonEntry.SourceKey
+	Source string `json:"source"`
+	// Subdir is the entry's subdir, entries of one repo with different subdirs install different dirs
+	Subdir string `json:"subdir,omitempty"`
+	// Entry is the display name of the config entry
+	Entry string `json:"entry,omitempty"`
+	// URL is the archive url after redirects
+	URL string `json:"url,omitempty"`
+	// Revision is the git commit or archive etag/hash that was installed
+	Revision string `json:"revision"`
+	// Ref is the branch, tag, commit or version a git entry was pinned to, Tag what a version resolved to
+	Ref    string `json:"ref,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Commit string `json:"commit,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Fingerprint is the entry's install options, see AddonEntry.InstallFingerprint
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Link is the working copy dir a linked addon dir points at, its files are not hashed
+	Link string `json:"link,omitempty"`
+
+	InstalledAt time.Time `json:"installed_at"`
+	ToolVersion string    `json:"tool_version,omitempty"`
+
+	// Files are the sha256 of each installed file, keyed by slash separated path relative to the addon dir
+	Files map[string]string `json:"files,omitempty"`
+}
+
+func NewMarker(entry AddonEntry) Marker {
+	marker := Marker{
+		Source:      entry.SourceKey(),
+		Subdir:      filepath.ToSlash(entry.Subdir),
+		Entry:       entry.DisplayName(),
+		URL:         entry.ResolvedURL,
+		Revision:    entry.Revision,
+		SHA256:      entry.SHA256,
+		Fingerprint: entry.InstallFingerprint(),
+		InstalledAt: time.Now().UTC(),
+		ToolVersion: Version,
+	}
+	if entry.Git != "" || entry.IsRelease() {
+		marker.Ref = entry.GitRef()
+		marker.Tag = entry.ResolvedTag
+	}
+	if entry.Git != "" {
+		marker.Commit = entry.Revision
+	}
+
+	return marker
+}
+
+// subdir is the subdir of the entry that installed the dir, older markers only have it in their fingerprint
+func (marker Marker) subdir() string {
+	if marker.Subdir != "" {
+		return marker.Subdir
+	}
+	for _, option := range strings.Split(marker.Fingerprint, ";") {
+		if subdir, ok := strings.CutPrefix(option, "subdir="); ok {
+			return subdir
+		}
+	}
+
+	return ""
+}
+
+// ChangedFiles compares an installed addon dir with the file hashes in its marker,
+// returning the paths that were modified, added or removed since install
+func (marker Marker) ChangedFiles(dir string) ([]string, error) {
+	changed := []string{}
+
+	current, err := util.HashDir(dir, MARKER)
+	if err != nil {
+		return changed, err
+	}
+
+	for path, hash := range marker.Files {
+		if current[path] != hash {
+			changed = append(changed, path)
+		}
+	}
+	for path := range current {
+		if _, ok := marker.Files[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// markerPath is the marker file of an addon dir. A linked addon's marker is kept beside its link,
+// ex. AddOns/.MyAddon.wow_addon_cli, so nothing is written into the working copy.
+func markerPath(dir string) string {
+	info, err := os.Lstat(dir)
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return linkMarkerPath(dir)
+	}
+
+	return filepath.Join(dir, MARKER)
+}
+
+func linkMarkerPath(dir string) string {
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+MARKER)
+}
+
+// ReadMarker reads the install manifest of an addon dir, ex. ReadMarker("AddOns/Bagnon")
+func ReadMarker(dir string) (*Marker, error) {
+	data, err := os.ReadFile(markerPath(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	marker := &Marker{}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return marker, nil
+	}
+
+	err = json.Unmarshal(data, marker)
+	if err != nil {
+		return nil, fmt.Errorf("error reading marker in %v: %w", dir, err)
+	}
+
+	return marker, nil
+}
+
+// WriteMarker records the hashes of the files in dir and writes the marker into it, or beside it for links
+func WriteMarker(dir string, marker Marker) error {
+	if marker.Link == "" {
+		files, err := util.HashDir(dir, MARKER)
+		if err != nil {
+			return err
+		}
+		marker.Files = files
+	}
+
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(markerPath(dir), data, 0644)
+}
+
+// ListMarkedDirs returns the addon dirs the tool manages, ie. that have a marker file
+// and are not skipped by prefix
+func ListMarkedDirs(conf Conf) ([]string, error) {
+	marked := []string{}
+
+	matches, err := filepath.Glob(conf.AddonsPath + SEP + "*")
+	if err != nil {
+		return marked, err
+	}
+	for _, dir := range matches {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return marked, err
+		}
+
+		// only clean up dirs, and links to working copies even when the working copy is gone
+		if !info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		markerFile := markerPath(dir)
+		log.Debug().Msgf("Checking for marker at %v", markerFile)
+		exists, _ := util.FileExists(markerFile)
+		if !exists {
+			continue
+		}
+
+		base := filepath.Base(dir)
+		shouldSkip := false
+		for _, prefix := range DefaultSkipCleanPrefixes {
+			if strings.HasPrefix(base, prefix) {
+				shouldSkip = true
+			}
+		}
+		for _, prefix := range conf.SkipCleanPrefixes {
+			if strings.HasPrefix(base, prefix) {
+				shouldSkip = true
+			}
+		}
+		if strings.HasPrefix(base, ".") {
+			shouldSkip = true
+		}
+
+		if shouldSkip {
+			continue
+		}
+
+		marked = append(marked, dir)
+	}
+
+	return marked, nil
+}
+
+// ListInstalled reads the markers of every managed addon dir, keyed by dir
+func ListInstalled(conf Conf) (map[string]*Marker, error) {
+	installed := map[string]*Marker{}
+
+	dirs, err := ListMarkedDirs(conf)
+	if err != nil {
+		return installed, err
+	}
+
+	for _, dir := range dirs {
+		marker, err := ReadMarker(dir)
+		if err != nil {
+			log.Warn().Err(err).Msgf("unreadable marker, treating %v as unknown", dir)
+			marker = &Marker{}
+		}
+		installed[dir] = marker
+	}
+
+	return installed, nil
+}
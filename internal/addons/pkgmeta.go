@@ -0,0 +1,364 @@
+package addons
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/go-git/go-git/v6/plumbing"
+	"gopkg.in/yaml.v3"
+)
+
+// packaging metadata files of the BigWigs packager, in the order it looks for them
+var PKGMETA_FILES = []string{".pkgmeta", "pkgmeta.yaml"}
+
+// Pkgmeta is the packaging metadata of an addon repo, see https://github.com/BigWigsMods/packager/wiki/Preparing-the-PackageMeta-File
+type Pkgmeta struct {
+	PackageAs string `yaml:"package-as"`
+	// Externals are checked out at their path, ex. Libs/LibStub
+	Externals map[string]PkgmetaExternal `yaml:"externals"`
+	// Ignore are paths or globs relative to the repo root left out of the package
+	Ignore []string `yaml:"ignore"`
+	// MoveFolders moves a folder of the package, ex. MyAddon/Modules/Foo, out to a top level addon dir ex. MyAddon_Foo
+	MoveFolders map[string]string `yaml:"move-folders"`
+}
+
+// PkgmetaExternal is a library or other repo the packager checks out into the package
+type PkgmetaExternal struct {
+	URL    string `yaml:"url"`
+	Type   string `yaml:"type"`
+	Branch string `yaml:"branch"`
+	Tag    string `yaml:"tag"`
+	Commit string `yaml:"commit"`
+	// Path checks out only this subdir of the external
+	Path string `yaml:"path"`
+}
+
+// UnmarshalYAML takes an external as a plain url or a mapping with url and options
+func (ext *PkgmetaExternal) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		ext.URL = node.Value
+		return nil
+	}
+
+	type plain PkgmetaExternal
+	return node.Decode((*plain)(ext))
+}
+
+// ApplyPkgmeta is whether a git entry's .pkgmeta is applied, it is unless pkgmeta = false
+func (entry AddonEntry) ApplyPkgmeta() bool {
+	return entry.Pkgmeta == nil || *entry.Pkgmeta
+}
+
+// ReadPkgmeta reads the packaging metadata in dir, nil if there is none
+func ReadPkgmeta(dir string) (*Pkgmeta, error) {
+	for _, name := range PKGMETA_FILES {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		meta := &Pkgmeta{}
+		err = yaml.Unmarshal(data, meta)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %v: %w", name, err)
+		}
+		return meta, nil
+	}
+
+	return nil, nil
+}
+
+// applyPkgmeta packages a checked out repo like the BigWigs packager before its TOC files are looked for:
+// externals are checked out, ignored paths removed, the repo dir renamed to package-as and move-folders
+// moved out next to it.
+func applyPkgmeta(ctx context.Context, conf Conf, entry AddonEntry, clonePath string) error {
+	meta, err := ReadPkgmeta(clonePath)
+	if err != nil || meta == nil {
+		return err
+	}
+	entry.Log().Info().Msgf("Packaging %v with its .pkgmeta", entry.Git)
+
+	err = checkoutExternals(ctx, conf, entry, meta, clonePath, 0)
+	if err != nil {
+		return err
+	}
+
+	err = removeIgnored(entry, meta.Ignore, clonePath)
+	if err != nil {
+		return err
+	}
+
+	if meta.PackageAs != "" && meta.PackageAs != filepath.Base(clonePath) {
+		if meta.PackageAs != filepath.Base(meta.PackageAs) || strings.HasPrefix(meta.PackageAs, ".") {
+			return fmt.Errorf(".pkgmeta of %v: package-as %q must be a plain folder name", entry.Git, meta.PackageAs)
+		}
+
+		packagePath := filepath.Join(filepath.Dir(clonePath), meta.PackageAs)
+		entry.Log().Debug().Msgf("Packaging %v as %v", clonePath, packagePath)
+		err = os.Rename(clonePath, packagePath)
+		if err != nil {
+			return err
+		}
+		clonePath = packagePath
+	}
+
+	// move-folders paths start with the package name, ex. MyAddon/Modules/Foo
+	root := filepath.Dir(clonePath)
+	for _, from := range sortedKeys(meta.MoveFolders) {
+		to := meta.MoveFolders[from]
+		src := filepath.Join(root, filepath.FromSlash(from))
+		dest := filepath.Join(root, to)
+		if !filepath.IsLocal(filepath.FromSlash(from)) || to != filepath.Base(to) || strings.HasPrefix(to, ".") {
+			return fmt.Errorf(".pkgmeta of %v: cannot move %v to %q", entry.Git, from, to)
+		}
+
+		exists, err := util.FileExists(src)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			entry.Log().Warn().Msgf(".pkgmeta moves %v which is not in the package", from)
+			continue
+		}
+
+		// the folder and its parents must be in the package, not a symlink to elsewhere
+		err = util.InsideDir(clonePath, src)
+		if err == nil {
+			var info os.FileInfo
+			info, err = os.Lstat(src)
+			if err == nil && info.Mode()&os.ModeSymlink != 0 {
+				err = fmt.Errorf("%v is a symlink", src)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf(".pkgmeta of %v: cannot move %v: %w", entry.Git, from, err)
+		}
+
+		entry.Log().Debug().Msgf("Moving %v to %v", src, dest)
+		err = os.Rename(src, dest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkoutExternals checks out the git externals of a .pkgmeta into dir. Externals with a .pkgmeta
+// of their own get their externals too, ex. a library bundling its dependencies.
+func checkoutExternals(ctx context.Context, conf Conf, entry AddonEntry, meta *Pkgmeta, dir string, depth int) error {
+	if len(meta.Externals) == 0 {
+		return nil
+	}
+	if depth >= MAX_SUBMODULE_DEPTH {
+		return fmt.Errorf(".pkgmeta externals of %v nest deeper than %d", entry.Git, MAX_SUBMODULE_DEPTH)
+	}
+
+	for _, extPath := range sortedKeys(meta.Externals) {
+		ext := meta.Externals[extPath]
+		if !filepath.IsLocal(filepath.FromSlash(extPath)) {
+			return fmt.Errorf(".pkgmeta of %v: external path %q must be inside the repo", entry.Git, extPath)
+		}
+		if !isGitExternal(ext) {
+			entry.Log().Warn().Msgf(".pkgmeta external %v from %v is not a git repo, skipping it", extPath, ext.URL)
+			continue
+		}
+
+		dest := filepath.Join(dir, filepath.FromSlash(extPath))
+		// the dest is removed before the external is checked out, a symlinked parent would lead it elsewhere
+		err := util.InsideDir(dir, dest)
+		if err != nil {
+			return fmt.Errorf(".pkgmeta of %v: external path %q: %w", entry.Git, extPath, err)
+		}
+		err = checkoutExternal(ctx, conf, entry, ext, dest, depth)
+		if err != nil {
+			return fmt.Errorf(".pkgmeta external %v from %v: %w (set pkgmeta = false on the entry to install the repo as is)", extPath, ext.URL, err)
+		}
+
+		nested, err := ReadPkgmeta(dest)
+		if err != nil {
+			return err
+		}
+		if nested != nil {
+			err = checkoutExternals(ctx, conf, entry, nested, dest, depth+1)
+			if err != nil {
+				return err
+			}
+			err = removeIgnored(entry, nested.Ignore, dest)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isGitExternal is true for externals the packager would check out with git, svn and hg are not supported
+func isGitExternal(ext PkgmetaExternal) bool {
+	if ext.URL == "" {
+		return false
+	}
+	if ext.Type != "" {
+		return ext.Type == "git"
+	}
+
+	// like the packager, svn urls point at a trunk or tags dir
+	u := strings.TrimSuffix(ext.URL, "/")
+	return !strings.HasPrefix(u, "svn:") && !strings.HasSuffix(u, "/trunk") && !strings.Contains(u, "/trunk/") && !strings.Contains(u, "/tags/")
+}
+
+func checkoutExternal(ctx context.Context, conf Conf, entry AddonEntry, ext PkgmetaExternal, dest string, depth int) error {
+	// the external is logged and downloaded as part of its entry
+	sub := AddonEntry{
+		Git:        ext.URL,
+		Branch:     ext.Branch,
+		Tag:        ext.Tag,
+		Commit:     ext.Commit,
+		Name:       entry.DisplayName(),
+		UniqueName: entry.UniqueName,
+	}
+	// the packager's latest is the newest tag, pre-releases included
+	if ext.Tag == "latest" {
+		sub.Tag = ""
+		sub.Channel = ChannelAlpha
+	}
+
+	mirrorPath, err := conf.MirrorPath(sub)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockMirror(mirrorPath)
+	if err != nil {
+		return err
+	}
+	unlock = sync.OnceFunc(unlock)
+	defer unlock()
+
+	repo, err := syncMirror(ctx, conf, sub, mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	if sub.FollowsVersion() {
+		tags, err := mirrorTags(repo)
+		if err != nil {
+			return err
+		}
+		sub.ResolvedTag, err = selectTag(sub, tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	hash, err := wantedCommit(repo, sub)
+	if errors.Is(err, plumbing.ErrObjectNotFound) && !conf.Offline {
+		entry.Log().Warn().Err(err).Msgf("could not resolve revision in mirror %v, cloning again", mirrorPath)
+		repo, err = cloneMirror(ctx, sub, mirrorPath, false)
+		if err != nil {
+			return err
+		}
+		hash, err = wantedCommit(repo, sub)
+	}
+	if err != nil {
+		return err
+	}
+
+	entry.Log().Info().Msgf("Checking out external %v at %v", ext.URL, hash)
+
+	// with a path only that subtree of the external goes to dest, it is checked out next to it first
+	exportDir := dest
+	if ext.Path != "" {
+		exportDir = dest + ".external"
+		defer os.RemoveAll(exportDir)
+	}
+	err = os.RemoveAll(dest)
+	if err != nil {
+		return err
+	}
+
+	links, err := exportCommit(ctx, conf, repo, hash, exportDir, filepath.FromSlash(ext.Path))
+	if err != nil {
+		return err
+	}
+
+	err = cacheMirror(conf, sub, repo, mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	if !entry.FetchSubmodules() {
+		links = nil
+	}
+	modules, err := readSubmodules(repo, hash, ext.URL, links)
+	if err != nil {
+		return err
+	}
+
+	// submodules lock their own mirrors, which may be this one
+	unlock()
+
+	err = exportSubmodules(ctx, conf, entry, modules, ext.URL, exportDir, links, depth)
+	if err != nil {
+		return err
+	}
+
+	if ext.Path != "" {
+		return os.Rename(filepath.Join(exportDir, filepath.FromSlash(ext.Path)), dest)
+	}
+
+	return nil
+}
+
+// removeIgnored deletes the paths matching the .pkgmeta ignore patterns, relative to dir.
+// Patterns come from the repo, so they and their matches must stay inside dir, not reached through a symlink.
+func removeIgnored(entry AddonEntry, patterns []string, dir string) error {
+	for _, pattern := range patterns {
+		local := filepath.FromSlash(pattern)
+		if !filepath.IsLocal(local) {
+			return fmt.Errorf(".pkgmeta of %v: ignore pattern %q must be inside the repo", entry.Git, pattern)
+		}
+
+		matches, err := filepath.Glob(filepath.Join(dir, local))
+		if err != nil {
+			return fmt.Errorf(".pkgmeta of %v: bad ignore pattern %q: %w", entry.Git, pattern, err)
+		}
+
+		for _, match := range matches {
+			// a symlink in the repo may lead the match outside it
+			err = util.InsideDir(dir, match)
+			if err != nil {
+				return fmt.Errorf(".pkgmeta of %v: ignore pattern %q: %w", entry.Git, pattern, err)
+			}
+
+			entry.Log().Debug().Msgf("Removing ignored %v", match)
+			err = os.RemoveAll(match)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
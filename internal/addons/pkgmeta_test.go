@@ -0,0 +1,108 @@
+package addons
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRemoveIgnored(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "clone")
+	for _, p := range []string{"clone/Foo.toc", "clone/tests/a.lua", "clone/docs/b.md", "outside.txt"} {
+		path := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := removeIgnored(AddonEntry{}, []string{"tests", "docs/*.md"}, dir)
+	if err != nil {
+		t.Fatalf("removeIgnored: %v", err)
+	}
+	for _, p := range []string{"clone/tests", "clone/docs/b.md"} {
+		if _, err := os.Stat(filepath.Join(root, p)); !os.IsNotExist(err) {
+			t.Errorf("%v was not removed", p)
+		}
+	}
+
+	for _, pattern := range []string{"../*", "../../..", "..", ".", "/tmp", "docs/../../outside.txt"} {
+		err := removeIgnored(AddonEntry{}, []string{pattern}, dir)
+		if err == nil {
+			t.Errorf("pattern %q was accepted", pattern)
+		}
+	}
+	for _, p := range []string{"outside.txt", "clone/Foo.toc"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Errorf("%v was removed: %v", p, err)
+		}
+	}
+
+	// a symlink in the repo pointing outside it
+	elsewhere := filepath.Join(root, "elsewhere")
+	if err := os.MkdirAll(elsewhere, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(elsewhere, "keep.lua"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(elsewhere, filepath.Join(dir, "Libs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeIgnored(AddonEntry{}, []string{"Libs/*"}, dir); err == nil {
+		t.Errorf("pattern through a symlink was accepted")
+	}
+	if err := removeIgnored(AddonEntry{}, []string{"Libs"}, dir); err != nil {
+		t.Errorf("removing the symlink itself: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "Libs")); !os.IsNotExist(err) {
+		t.Errorf("symlink was not removed")
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, "keep.lua")); err != nil {
+		t.Errorf("file behind the symlink was removed: %v", err)
+	}
+}
+
+func TestApplyPkgmetaSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		pkgmeta string
+	}{
+		{name: "move-folders under a symlink", pkgmeta: "move-folders:\n  clone/Libs/Mine: Mine\n"},
+		{name: "move-folders of a symlink", pkgmeta: "move-folders:\n  clone/Libs: Mine\n"},
+		{name: "external under a symlink", pkgmeta: "externals:\n  Libs/LibStub: https://example.com/LibStub.git\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "clone")
+			elsewhere := filepath.Join(root, "elsewhere")
+			for _, d := range []string{filepath.Join(elsewhere, "Mine"), filepath.Join(elsewhere, "LibStub"), dir} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Symlink(elsewhere, filepath.Join(dir, "Libs")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, ".pkgmeta"), []byte(test.pkgmeta), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyPkgmeta(context.Background(), Conf{}, AddonEntry{}, dir)
+			if err == nil {
+				t.Fatal("applied a .pkgmeta that reaches through a symlink")
+			}
+			for _, d := range []string{"Mine", "LibStub"} {
+				if _, err := os.Stat(filepath.Join(elsewhere, d)); err != nil {
+					t.Errorf("%v behind the symlink was changed: %v", d, err)
+				}
+			}
+		})
+	}
+}
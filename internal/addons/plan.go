@@ -0,0 +1,227 @@
+package addons
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// Plan is the reconciled set of actions for a config. The entries are already fetched
+// into the download path, so applying it does exactly what was planned.
+type Plan struct {
+	CreatedAt  time.Time `json:"created_at"`
+	AddonsPath string    `json:"addons_path"`
+	Actions    []Action  `json:"actions"`
+}
+
+// BuildPlan hydrates and fetches every entry and works out the changes to AddOns
+// without touching it. The downloads are kept until CleanDownloads.
+func BuildPlan(conf Conf) (*Plan, error) {
+	// ctrl-c cancels the fetches in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	plan := &Plan{
+		CreatedAt:  time.Now(),
+		AddonsPath: conf.AddonsPath,
+	}
+
+	actions, err := Reconcile(ctx, conf)
+	plan.Actions = actions
+	return plan, err
+}
+
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{}
+	err = json.Unmarshal(data, plan)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan %v: %w", path, err)
+	}
+
+	return plan, nil
+}
+
+func (plan Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+// Print writes the directories each action creates, replaces or deletes
+func (plan Plan) Print(w io.Writer) {
+	changes := 0
+	for _, action := range plan.Actions {
+		source := action.Entry.SourceKey()
+		if action.Kind == ActionRemove {
+			source = "(not in config)"
+		}
+		fmt.Fprintf(w, "%-9s %s", action.Kind, source)
+		if action.Entry.Revision != "" {
+			fmt.Fprintf(w, " @ %s", action.Entry.Revision)
+		}
+		fmt.Fprintln(w)
+
+		for _, inst := range action.Installs {
+			symbol := "+"
+			if inst.Op == InstallReplace {
+				symbol = "~"
+			}
+			fmt.Fprintf(w, "  %s %-30s %s\n", symbol, inst.Name, inst.Op)
+			changes++
+		}
+		for _, skip := range action.Skipped {
+			fmt.Fprintf(w, "    %-30s skipped, %s\n", skip.Name, skip.Reason)
+		}
+		for _, dir := range action.Deletes {
+			fmt.Fprintf(w, "  - %-30s delete\n", filepath.Base(dir))
+			changes++
+		}
+	}
+
+	if changes == 0 {
+		fmt.Fprintln(w, "No changes.")
+	}
+}
+
+// CleanDownloads removes everything the plan downloaded
+func (plan Plan) CleanDownloads(conf Conf) {
+	for _, action := range plan.Actions {
+		err := CleanDownload(conf, action.CleanupPaths)
+		if err != nil {
+			log.Error().Err(err).Msgf("error cleaning up download for entry %+v", action.Entry)
+		}
+	}
+}
+
+// Verify checks that AddOns and the downloads are still in the state the plan was made against
+func (plan Plan) Verify(conf Conf) error {
+	if plan.AddonsPath != conf.AddonsPath {
+		return fmt.Errorf("plan was made for %v, not %v", plan.AddonsPath, conf.AddonsPath)
+	}
+
+	for _, action := range plan.Actions {
+		for _, inst := range action.Installs {
+			exists, err := util.FileExists(inst.SrcDir)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("planned download %v is missing, plan again", inst.SrcDir)
+			}
+
+			exists, err = util.FileExists(filepath.Join(conf.AddonsPath, inst.Name))
+			if err != nil {
+				return err
+			}
+			if exists != (inst.Op == InstallReplace) {
+				return fmt.Errorf("%v changed since the plan was made, plan again", inst.Name)
+			}
+		}
+
+		for _, dir := range slices.Concat(action.Deletes, action.Dirs) {
+			exists, err := util.FileExists(dir)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("%v changed since the plan was made, plan again", filepath.Base(dir))
+			}
+		}
+	}
+
+	return nil
+}
+
+// Apply makes the planned changes as one transaction, if any step fails
+// or the run is interrupted the AddOns dir is rolled back to how it was
+func Apply(conf Conf, plan *Plan) error {
+	if conf.SnapshotName == "" {
+		conf.SnapshotName = NewSnapshotName()
+	}
+	defer func() {
+		err := PruneBackups(conf)
+		if err != nil {
+			log.Error().Err(err).Msg("error pruning backups")
+		}
+	}()
+
+	err := RecoverTransaction(conf)
+	if err != nil {
+		return fmt.Errorf("error recovering previous run: %w", err)
+	}
+
+	err = plan.Verify(conf)
+	if err != nil {
+		return err
+	}
+
+	// catch ctrl-c so the transaction can be rolled back instead of leaving a half install
+	sigCh, stop := notifyInterrupt()
+	defer stop()
+
+	txn := NewTransaction(conf)
+	err = ApplyActions(conf, txn, plan.Actions)
+	if err == nil {
+		err = checkInterrupt(sigCh)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Run failed, rolling back AddOns changes")
+		rbErr := txn.Rollback()
+		if rbErr != nil {
+			return fmt.Errorf("%w, and error rolling back: %v", err, rbErr)
+		}
+		return err
+	}
+
+	err = txn.Commit()
+	if err != nil {
+		return err
+	}
+
+	if conf.CacheMaxSize != "" && conf.CachePath != "" {
+		maxSize, err := ParseSize(conf.CacheMaxSize)
+		if err != nil {
+			return err
+		}
+		_, err = GCCache(conf, maxSize)
+		if err != nil {
+			log.Error().Err(err).Msg("error collecting cache garbage")
+		}
+	}
+
+	if conf.Frozen {
+		return nil
+	}
+
+	return UpdateLockfile(conf, plan)
+}
+
+func notifyInterrupt() (chan os.Signal, func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	return sigCh, func() { signal.Stop(sigCh) }
+}
+
+func checkInterrupt(sigCh chan os.Signal) error {
+	select {
+	case sig := <-sigCh:
+		return fmt.Errorf("interrupted by %v", sig)
+	default:
+		return nil
+	}
+}
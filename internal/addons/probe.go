@@ -0,0 +1,207 @@
+package addons
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// forges whose repo urls are known without asking the server, by host
+var GITHUB_HOSTS = []string{"github.com", "www.github.com"}
+var GITLAB_HOSTS = []string{"gitlab.com"}
+var GITEA_HOSTS = []string{"codeberg.org", "gitea.com"}
+
+// ResolveSources works out whether each url entry without a .git or archive extension is a git repo
+// or an archive, ex. https://github.com/owner/repo or https://example.com/download?id=123.
+// Known forge urls are understood directly, others are probed. Resolved entries get git or zip set.
+func (c *Conf) ResolveSources() error {
+	for i := range c.Addons {
+		entry := &c.Addons[i]
+		if entry.Url == "" || entry.Git != "" || entry.Zip != "" || entry.Path != "" {
+			continue
+		}
+
+		// urls with an extension or file:// urls need no probing
+		hydrated := *entry
+		if hydrated.Hydrate() != nil || hydrated.SourceKey() != "" {
+			continue
+		}
+
+		err := resolveURL(*c, entry)
+		if err != nil {
+			return fmt.Errorf("entry %v: cannot tell if it is a git repo or an archive: %w. Set git = or zip = on the entry instead of url", entry.Url, err)
+		}
+	}
+
+	return nil
+}
+
+func resolveURL(conf Conf, entry *AddonEntry) error {
+	u, err := url.Parse(entry.Url)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	if forgeRepoURL(entry, u) {
+		entry.Log().Debug().Msgf("Resolved %v to git repo %v", entry.Url, entry.Git)
+		return nil
+	}
+
+	if conf.Offline {
+		if cached, _ := FindCached(conf, CacheArchive, entry.Url, ""); cached != nil {
+			entry.Zip = entry.Url
+			return nil
+		}
+		if cached, _ := FindCached(conf, CacheGit, entry.Url, ""); cached != nil {
+			entry.Git = entry.Url
+			return nil
+		}
+		return fmt.Errorf("offline and it is not in the cache")
+	}
+
+	isGit, err := probeGit(entry.Url)
+	if err != nil {
+		log.Debug().Err(err).Msgf("git probe of %v failed", entry.Url)
+	}
+	if isGit {
+		entry.Log().Debug().Msgf("Resolved %v to a git repo", entry.Url)
+		entry.Git = entry.Url
+		return nil
+	}
+
+	err = probeArchive(entry.Url)
+	if err != nil {
+		return err
+	}
+	entry.Log().Debug().Msgf("Resolved %v to an archive", entry.Url)
+	entry.Zip = entry.Url
+
+	return nil
+}
+
+// forgeRepoURL sets git, and a branch for tree urls, on entries whose url is a repo page of a known forge,
+// ex. https://github.com/owner/repo/tree/develop. Release downloads and other pages are left to the probes.
+func forgeRepoURL(entry *AddonEntry, u *url.URL) bool {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	host := strings.ToLower(u.Host)
+
+	repoPath := []string{}
+	ref := ""
+	switch {
+	case hostIn(host, GITHUB_HOSTS):
+		// owner/repo or owner/repo/tree/<branch>
+		if len(parts) == 2 {
+			repoPath = parts
+		} else if len(parts) >= 4 && parts[2] == "tree" {
+			repoPath, ref = parts[:2], strings.Join(parts[3:], "/")
+		}
+	case hostIn(host, GITLAB_HOSTS):
+		// group/subgroup/repo or group/repo/-/tree/<branch>
+		before, after, found := strings.Cut(strings.Trim(u.Path, "/"), "/-/")
+		if !found && len(parts) >= 2 {
+			repoPath = parts
+		} else if rest, ok := strings.CutPrefix(after, "tree/"); found && ok {
+			repoPath, ref = strings.Split(before, "/"), rest
+		}
+	case hostIn(host, GITEA_HOSTS):
+		// owner/repo or owner/repo/src/branch/<branch>
+		if len(parts) == 2 {
+			repoPath = parts
+		} else if len(parts) >= 5 && parts[2] == "src" && parts[3] == "branch" {
+			repoPath, ref = parts[:2], strings.Join(parts[4:], "/")
+		}
+	}
+
+	if len(repoPath) < 2 {
+		return false
+	}
+
+	repo := *u
+	repo.Path = "/" + path.Join(repoPath...) + ".git"
+	repo.RawQuery = ""
+	repo.Fragment = ""
+	entry.Git = repo.String()
+	if ref != "" && entry.GitRef() == "" {
+		entry.Branch = ref
+	}
+
+	return true
+}
+
+func hostIn(host string, hosts []string) bool {
+	for _, h := range hosts {
+		if host == h {
+			return true
+		}
+	}
+
+	return false
+}
+
+// probeGit asks for the url's git smart HTTP ref advertisement, only git servers answer it
+func probeGit(rawURL string) (bool, error) {
+	client := http.Client{
+		Timeout: time.Second * 20,
+	}
+
+	infoRefs := strings.TrimSuffix(rawURL, "/") + "/info/refs?service=git-upload-pack"
+	resp, err := client.Get(infoRefs)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	return mediaType == "application/x-git-upload-pack-advertisement", nil
+}
+
+// probeArchive checks the url downloads a file that could be an archive, by its Content-Type,
+// Content-Disposition file name or final url. A generic binary type is taken too, the format
+// is detected from the downloaded bytes.
+func probeArchive(rawURL string) error {
+	client := http.Client{
+		Timeout: time.Second * 20,
+	}
+
+	resp, err := client.Head(rawURL)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp.Body.Close()
+		resp, err = client.Get(rawURL)
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("it is not a git repo and downloading it returns %v", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if util.ArchiveFormatFromContentType(contentType) != "" ||
+		util.ArchiveFormatFromName(contentDispositionFilename(resp.Header.Get("Content-Disposition"))) != "" ||
+		util.ArchiveFormatFromName(resp.Request.URL.Path) != "" {
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/octet-stream" || mediaType == "binary/octet-stream" {
+		return nil
+	}
+
+	return fmt.Errorf("it is not a git repo and downloads %q which is not an archive", contentType)
+}
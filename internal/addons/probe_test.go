@@ -0,0 +1,174 @@
+package addons
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestForgeRepoURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		entry  AddonEntry
+		git    string
+		branch string
+	}{
+		{url: "https://github.com/owner/Addon", git: "https://github.com/owner/Addon.git"},
+		{url: "https://www.GitHub.com/owner/Addon/?tab=readme#install", git: "https://www.GitHub.com/owner/Addon.git"},
+		{url: "https://github.com/owner/Addon/tree/develop", git: "https://github.com/owner/Addon.git", branch: "develop"},
+		{url: "https://github.com/owner/Addon/tree/feature/wrath", git: "https://github.com/owner/Addon.git", branch: "feature/wrath"},
+		{url: "https://github.com/owner/Addon/tree/develop", entry: AddonEntry{Tag: "v1.0.0"}, git: "https://github.com/owner/Addon.git"},
+		{url: "https://github.com/owner/Addon/releases/download/v1/Addon.zip"},
+		{url: "https://github.com/owner"},
+		{url: "https://gitlab.com/group/Addon", git: "https://gitlab.com/group/Addon.git"},
+		{url: "https://gitlab.com/group/sub/Addon", git: "https://gitlab.com/group/sub/Addon.git"},
+		{url: "https://gitlab.com/group/sub/Addon/-/tree/wrath/classic", git: "https://gitlab.com/group/sub/Addon.git", branch: "wrath/classic"},
+		{url: "https://gitlab.com/group/Addon/-/releases"},
+		{url: "https://gitlab.com/group"},
+		{url: "https://codeberg.org/owner/Addon", git: "https://codeberg.org/owner/Addon.git"},
+		{url: "https://gitea.com/owner/Addon/src/branch/develop", git: "https://gitea.com/owner/Addon.git", branch: "develop"},
+		{url: "https://codeberg.org/owner/Addon/src/tag/v1.0.0"},
+		{url: "https://codeberg.org/owner/Addon/releases"},
+		{url: "https://example.com/owner/Addon"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			u, err := url.Parse(test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			entry := test.entry
+			ok := forgeRepoURL(&entry, u)
+			if ok != (test.git != "") {
+				t.Fatalf("got %v git %q, want git %q", ok, entry.Git, test.git)
+			}
+			if entry.Git != test.git || entry.Branch != test.branch {
+				t.Errorf("got git %q branch %q, want %q %q", entry.Git, entry.Branch, test.git, test.branch)
+			}
+		})
+	}
+}
+
+// probeServer answers like a git server under /repo and like file hosts elsewhere
+func probeServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repo/info/refs":
+			if r.URL.Query().Get("service") != "git-upload-pack" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			w.Write([]byte("001e# service=git-upload-pack\n0000"))
+		case "/download":
+			// some hosts refuse HEAD
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Disposition", `attachment; filename="Addon-1.0.tar.gz"`)
+		case "/binary":
+			w.Header().Set("Content-Type", "application/octet-stream")
+		case "/typed":
+			w.Header().Set("Content-Type", "application/x-7z-compressed")
+		case "/latest":
+			http.Redirect(w, r, "/files/Addon-1.0.zip?token=abc", http.StatusFound)
+		case "/files/Addon-1.0.zip":
+			w.Header().Set("Content-Type", "text/plain")
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestResolveSources(t *testing.T) {
+	server := probeServer(t)
+
+	tests := []struct {
+		path string
+		git  bool
+		zip  bool
+		err  string
+	}{
+		{path: "/repo", git: true},
+		{path: "/repo/", git: true},
+		{path: "/download", zip: true},
+		{path: "/binary", zip: true},
+		{path: "/typed", zip: true},
+		{path: "/latest", zip: true},
+		{path: "/page", err: `downloads "text/html; charset=utf-8" which is not an archive`},
+		{path: "/missing", err: "returns 404 Not Found"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			conf := Conf{Addons: []AddonEntry{{Url: server.URL + test.path}}}
+			err := conf.ResolveSources()
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %+v error %v, want %q", conf.Addons[0], err, test.err)
+				}
+				if !strings.Contains(err.Error(), "Set git = or zip =") {
+					t.Errorf("error should say how to set the source: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entry := conf.Addons[0]
+			if (entry.Git != "") != test.git || (entry.Zip != "") != test.zip {
+				t.Errorf("got git %q zip %q, want git %v zip %v", entry.Git, entry.Zip, test.git, test.zip)
+			}
+			if entry.Git != "" && entry.Git != entry.Url || entry.Zip != "" && entry.Zip != entry.Url {
+				t.Errorf("resolved source %+v should be the url", entry)
+			}
+		})
+	}
+}
+
+func TestResolveSourcesSkipsKnownURLs(t *testing.T) {
+	// nothing listens here, a probe would fail
+	conf := Conf{Addons: []AddonEntry{
+		{Url: "http://127.0.0.1:1/Addon.git"},
+		{Url: "http://127.0.0.1:1/Addon.zip"},
+		{Url: "http://127.0.0.1:1/Addon", Git: "http://127.0.0.1:1/Addon"},
+		{Url: "https://github.com/owner/Addon/tree/develop"},
+	}}
+	if err := conf.ResolveSources(); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Addons[0].Git != "" || conf.Addons[1].Zip != "" {
+		t.Errorf("urls with an extension are hydrated later, got %+v", conf.Addons[:2])
+	}
+	if got := conf.Addons[3]; got.Git != "https://github.com/owner/Addon.git" || got.Branch != "develop" {
+		t.Errorf("got %+v, want the forge repo and branch", got)
+	}
+
+	conf = Conf{Addons: []AddonEntry{{Url: "ftp://example.com/Addon"}}}
+	if err := conf.ResolveSources(); err == nil || !strings.Contains(err.Error(), "unsupported scheme") {
+		t.Errorf("got %v, want the scheme refused", err)
+	}
+}
+
+func TestProbeGitServer(t *testing.T) {
+	repoDir := t.TempDir()
+	commitFiles(t, repoDir, map[string]string{"Foo/Foo.toc": "## Interface: 30300\n## Title: Foo\n"})
+	repoURL := gitServer(t, repoDir)
+
+	isGit, err := probeGit(repoURL)
+	if err != nil || !isGit {
+		t.Errorf("got %v %v, want git http-backend taken for a git repo", isGit, err)
+	}
+}
@@ -0,0 +1,467 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/storage/memory"
+	"github.com/rs/zerolog/log"
+)
+
+type ActionKind string
+
+const (
+	ActionAdd       ActionKind = "add"
+	ActionUpdate    ActionKind = "update"
+	ActionUnchanged ActionKind = "unchanged"
+	ActionRemove    ActionKind = "remove"
+)
+
+type InstallOp string
+
+const (
+	InstallCreate  InstallOp = "create"
+	InstallReplace InstallOp = "replace"
+)
+
+// AddonInstall is one addon dir to install, Name is the dir name under AddOns
+type AddonInstall struct {
+	Name   string    `json:"name"`
+	SrcDir string    `json:"src_dir"`
+	Op     InstallOp `json:"op"`
+}
+
+// Action is what reconciling decided to do for a config entry, or for an installed dir
+// that no entry claims anymore
+type Action struct {
+	Kind  ActionKind `json:"kind"`
+	Entry AddonEntry `json:"entry"`
+	// addon dirs to install for add and update
+	Installs []AddonInstall `json:"installs,omitempty"`
+	// addon dirs of the download left out by the entry's include/exclude
+	Skipped []SkippedInstall `json:"skipped,omitempty"`
+	// installed dirs of the entry before the run
+	Dirs []string `json:"dirs,omitempty"`
+	// installed dirs to delete, ones the entry no longer produces or no entry claims
+	Deletes []string `json:"deletes,omitempty"`
+	// downloads to remove once the run is done
+	CleanupPaths []string `json:"cleanup_paths,omitempty"`
+}
+
+// Reconcile compares the desired addons in the config with the installed ones (via markers).
+// Entries whose source revision matches what is installed are unchanged and not fetched again,
+// installed dirs that no entry produces anymore are removed.
+func Reconcile(ctx context.Context, conf Conf) ([]Action, error) {
+	actions := []Action{}
+
+	installed, err := ListInstalled(conf)
+	if err != nil {
+		return actions, err
+	}
+
+	lock := &Lockfile{}
+	if conf.Frozen {
+		lock, err = ReadLockfile(conf.LockPath)
+		if err != nil {
+			return actions, err
+		}
+	}
+
+	for _, entry := range conf.Addons {
+		// normalize name from Git and other keys
+		err = entry.Hydrate()
+		if err != nil {
+			return actions, fmt.Errorf("error hydrating entry %+v: %w", entry, err)
+		}
+
+		if entry.UniqueName == "" {
+			log.Warn().Msgf("entry name is empty, skipping: %+v", entry)
+			continue
+		}
+
+		// only a frozen install is held to the lockfile
+		entry.Locked = nil
+		if conf.Frozen {
+			entry.Locked = lock.Find(entry)
+			if entry.Locked == nil {
+				return actions, fmt.Errorf("frozen install but %v is not in lockfile %v", entry.SourceKey(), conf.LockPath)
+			}
+			if entry.Locked.Ref != entry.GitRef() {
+				return actions, fmt.Errorf("frozen install but %v is locked at %q and the config pins %q, update the lockfile first", entry.SourceKey(), entry.Locked.Ref, entry.GitRef())
+			}
+		}
+
+		action := Action{
+			Kind:  ActionAdd,
+			Entry: entry,
+			Dirs:  installedDirsForEntry(installed, entry),
+		}
+		if len(action.Dirs) > 0 {
+			action.Kind = ActionUpdate
+		}
+		actions = append(actions, action)
+	}
+
+	// fetching runs concurrently, everything after is in config order so the result is deterministic
+	errs := fetchActions(ctx, conf, installed, actions)
+	for _, err := range errs {
+		if err != nil {
+			return actions, err
+		}
+	}
+
+	claimed := map[string]bool{}
+	for i := range actions {
+		action := &actions[i]
+		if action.Kind == ActionUnchanged {
+			for _, d := range action.Dirs {
+				claimed[d] = true
+			}
+			continue
+		}
+
+		installs, err := DiscoverInstalls(conf, action.Entry)
+		if err != nil {
+			return actions, fmt.Errorf("error unpacking entry: %+v, error: %w", action.Entry, err)
+		}
+
+		installs, action.Skipped, err = filterInstalls(action.Entry, installs)
+		if err != nil {
+			return actions, err
+		}
+		installs, err = renameInstalls(action.Entry, installs)
+		if err != nil {
+			return actions, err
+		}
+		for _, inst := range installs {
+			missing, err := MissingTOCReferences(inst.SrcDir)
+			if err != nil {
+				action.Entry.Log().Warn().Err(err).Msgf("could not check the TOC files of %v", inst.Name)
+			}
+			for _, ref := range missing {
+				action.Entry.Log().Warn().Msgf("%v: TOC file loads %v which is missing", inst.Name, ref)
+			}
+		}
+
+		err = verifyLockedFolders(action.Entry, installs)
+		if err != nil {
+			return actions, err
+		}
+		for i, inst := range installs {
+			dest := filepath.Join(conf.AddonsPath, inst.Name)
+			if claimed[dest] {
+				return actions, fmt.Errorf("entry %v installs %v which another entry already installs", action.Entry.SourceKey(), inst.Name)
+			}
+			claimed[dest] = true
+
+			installs[i].Op = InstallCreate
+			exists, err := util.FileExists(dest)
+			if err != nil {
+				return actions, err
+			}
+			if exists {
+				installs[i].Op = InstallReplace
+			}
+		}
+		action.Installs = installs
+	}
+
+	// dirs no entry claims are deleted, attributed to the entry that installed them if it's still configured
+	orphans := []string{}
+	for dir := range installed {
+		if claimed[dir] {
+			continue
+		}
+
+		owned := false
+		for i := range actions {
+			if slices.Contains(actions[i].Dirs, dir) {
+				actions[i].Deletes = append(actions[i].Deletes, dir)
+				owned = true
+			}
+		}
+		if !owned && !conf.KeepUnlisted {
+			orphans = append(orphans, dir)
+		}
+	}
+
+	sort.Strings(orphans)
+	for _, dir := range orphans {
+		actions = append(actions, Action{
+			Kind:    ActionRemove,
+			Dirs:    []string{dir},
+			Deletes: []string{dir},
+		})
+	}
+
+	return actions, nil
+}
+
+// resolveAction works out if an entry is unchanged, fetching it when it is not.
+// It runs on a fetch worker so it only touches its own action.
+func resolveAction(ctx context.Context, conf Conf, installed map[string]*Marker, action *Action) error {
+	entry := action.Entry
+	entry.Log().Info().Msgf("Processing entry: %+v", entry)
+
+	if action.Kind == ActionUpdate && !conf.PrecleanBliz {
+		rev := ""
+		if entry.Locked != nil && entry.Git != "" {
+			rev = entry.Locked.Commit
+		} else if conf.Offline {
+			rev = cachedRevision(conf, entry)
+		} else {
+			var err error
+			rev, err = RemoteRevision(ctx, conf, entry)
+			if err != nil {
+				entry.Log().Warn().Err(err).Msgf("could not resolve remote revision, fetching %v", entry.SourceKey())
+			}
+		}
+
+		if rev != "" && isInstalledRevision(installed, entry, action.Dirs, rev) {
+			entry.Log().Info().Msgf("Unchanged %v at %v", entry.SourceKey(), rev)
+			action.Kind = ActionUnchanged
+			action.Entry.Revision = rev
+			return nil
+		}
+	}
+
+	var err error
+	action.CleanupPaths, err = FetchEntry(ctx, conf, &action.Entry)
+	if err != nil {
+		return fmt.Errorf("error fetching entry: %+v, error: %w", entry, err)
+	}
+
+	// the remote revision may not be known before fetching, ex. zips without an etag
+	if action.Kind == ActionUpdate && !conf.PrecleanBliz && isInstalledRevision(installed, entry, action.Dirs, action.Entry.Revision) {
+		entry.Log().Info().Msgf("Unchanged %v at %v", entry.SourceKey(), action.Entry.Revision)
+		action.Kind = ActionUnchanged
+	}
+
+	return nil
+}
+
+// ApplyActions makes the AddOns changes for reconciled actions within a transaction
+func ApplyActions(conf Conf, txn *Transaction, actions []Action) error {
+	for _, action := range actions {
+		if action.Kind == ActionAdd || action.Kind == ActionUpdate {
+			action.Entry.Log().Info().Msgf("Installing %v (%v) at %v", action.Entry.SourceKey(), action.Kind, action.Entry.Revision)
+			err := InstallEntry(txn, action.Entry, action.Installs)
+			if err != nil {
+				return fmt.Errorf("error unpacking entry: %+v, error: %w", action.Entry, err)
+			}
+		}
+
+		for _, dir := range action.Deletes {
+			log.Info().Msgf("Removing %v", filepath.Base(dir))
+			err := txn.Remove(dir)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func installedDirsForEntry(installed map[string]*Marker, entry AddonEntry) []string {
+	dirs := []string{}
+	for dir, marker := range installed {
+		if entry.ownsSource(marker.Source, marker.subdir()) {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	return dirs
+}
+
+func isInstalledRevision(installed map[string]*Marker, entry AddonEntry, dirs []string, rev string) bool {
+	if rev == "" || len(dirs) == 0 {
+		return false
+	}
+
+	for _, d := range dirs {
+		if installed[d].Revision != rev || installed[d].Fingerprint != entry.InstallFingerprint() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RemoteRevision looks up the current revision of an entry's source without downloading it.
+// An empty revision means it can't be known until fetched.
+func RemoteRevision(ctx context.Context, conf Conf, entry AddonEntry) (string, error) {
+	if entry.IsLocal() {
+		return localRevision(entry)
+	}
+
+	if entry.IsRelease() {
+		err := resolveRelease(ctx, conf, &entry)
+		return entry.Revision, err
+	}
+
+	if entry.Git != "" {
+		remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+			Name: "origin",
+			URLs: []string{entry.Git},
+		})
+
+		refs, err := remote.ListContext(ctx, &git.ListOptions{
+			PeelingOption: git.AppendPeeled,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return refHash(entry, refs)
+	}
+
+	if entry.Zip != "" {
+		client := http.Client{
+			Timeout: time.Second * 20,
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, entry.Zip, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", nil
+		}
+
+		return archiveRevision(resp.Header.Get("ETag"), ""), nil
+	}
+
+	return "", nil
+}
+
+// cachedRevision is the most recently cached revision of an entry, used instead of the remote when offline
+func cachedRevision(conf Conf, entry AddonEntry) string {
+	// local sources are at hand offline too
+	if entry.IsLocal() {
+		rev, _ := localRevision(entry)
+		return rev
+	}
+
+	kind, url := CacheArchive, entry.SourceKey()
+	if entry.Git != "" {
+		kind, url = CacheGit, entry.Git
+	}
+	// the cached revision of a mirror is its HEAD, pinned entries resolve theirs when checked out
+	if entry.GitRef() != "" {
+		return ""
+	}
+
+	item, err := FindCached(conf, kind, url, "")
+	if err != nil || item == nil {
+		return ""
+	}
+
+	return item.Revision
+}
+
+// refHash finds the commit an entry's branch, tag, commit or version pin resolves to in a remote ref listing.
+// A short commit that no ref points at can't be known until fetched.
+func refHash(entry AddonEntry, refs []*plumbing.Reference) (string, error) {
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+
+	if entry.FollowsVersion() {
+		tags := []string{}
+		for _, ref := range refs {
+			if ref.Name().IsTag() && !strings.HasSuffix(ref.Name().String(), "^{}") {
+				tags = append(tags, ref.Name().Short())
+			}
+		}
+		tag, err := selectTag(entry, tags)
+		if err != nil {
+			return "", err
+		}
+		entry.Tag = tag
+	}
+
+	switch {
+	case entry.Branch != "":
+		ref, ok := byName[plumbing.NewBranchReferenceName(entry.Branch)]
+		if !ok {
+			return "", fmt.Errorf("branch %v not found in %v", entry.Branch, entry.Git)
+		}
+		return ref.Hash().String(), nil
+	case entry.Tag != "":
+		// annotated tags point at a tag object, the peeled ref points at its commit
+		name := plumbing.NewTagReferenceName(entry.Tag)
+		if ref, ok := byName[name+"^{}"]; ok {
+			return ref.Hash().String(), nil
+		}
+		ref, ok := byName[name]
+		if !ok {
+			return "", fmt.Errorf("tag %v not found in %v", entry.Tag, entry.Git)
+		}
+		return ref.Hash().String(), nil
+	case entry.Commit != "":
+		if len(entry.Commit) == 40 {
+			return strings.ToLower(entry.Commit), nil
+		}
+		for _, ref := range refs {
+			if strings.HasPrefix(ref.Hash().String(), strings.ToLower(entry.Commit)) {
+				return ref.Hash().String(), nil
+			}
+		}
+		return "", nil
+	}
+
+	return headHash(refs), nil
+}
+
+// headHash finds the commit HEAD points to in a remote ref listing
+func headHash(refs []*plumbing.Reference) string {
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+
+	head, ok := byName[plumbing.HEAD]
+	if !ok {
+		return ""
+	}
+
+	if head.Type() == plumbing.SymbolicReference {
+		target, ok := byName[head.Target()]
+		if !ok {
+			return ""
+		}
+		return target.Hash().String()
+	}
+
+	return head.Hash().String()
+}
+
+// archiveRevision prefers the server etag so it can be compared before downloading
+func archiveRevision(etag string, sha256Hex string) string {
+	if etag != "" {
+		return "etag:" + etag
+	}
+	if sha256Hex != "" {
+		return "sha256:" + sha256Hex
+	}
+
+	return ""
+}
@@ -0,0 +1,230 @@
+package addons
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testConf is a config with AddOns, downloads, backups, the cache and the lockfile in a temp dir
+func testConf(t *testing.T, entries ...AddonEntry) Conf {
+	t.Helper()
+	root := t.TempDir()
+	conf := Conf{
+		AddonsPath:   filepath.Join(root, "AddOns"),
+		DownloadPath: filepath.Join(root, "downloads"),
+		BackupPath:   filepath.Join(root, "backups"),
+		CachePath:    filepath.Join(root, "cache"),
+		LockPath:     filepath.Join(root, "config.lock"),
+		Addons:       entries,
+	}
+	if err := os.MkdirAll(conf.AddonsPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return conf
+}
+
+// install plans and applies the config, returning the kind of each action by entry name or dir
+func install(t *testing.T, conf Conf) map[string]ActionKind {
+	t.Helper()
+	plan, err := BuildPlan(conf)
+	defer plan.CleanDownloads(conf)
+	if err != nil {
+		t.Fatalf("planning: %v", err)
+	}
+	if err := Apply(conf, plan); err != nil {
+		t.Fatalf("applying: %v", err)
+	}
+
+	kinds := map[string]ActionKind{}
+	for _, action := range plan.Actions {
+		name := action.Entry.DisplayName()
+		if action.Kind == ActionRemove {
+			name = filepath.Base(action.Dirs[0])
+		}
+		kinds[name] = action.Kind
+	}
+
+	return kinds
+}
+
+func TestReconcileSubdirsOfOneRepo(t *testing.T) {
+	repoDir := t.TempDir()
+	commitFiles(t, repoDir, map[string]string{
+		"src/Foo/Foo.toc":   "## Interface: 30300\n## Title: Foo\n",
+		"extra/Bar/Bar.toc": "## Interface: 30300\n## Title: Bar\n",
+	})
+
+	conf := testConf(t,
+		AddonEntry{Name: "foo", Git: repoDir, Subdir: "src"},
+		AddonEntry{Name: "bar", Git: repoDir, Subdir: "extra"},
+	)
+
+	kinds := install(t, conf)
+	if kinds["foo"] != ActionAdd || kinds["bar"] != ActionAdd {
+		t.Fatalf("first run: %v", kinds)
+	}
+	for _, dir := range []string{"Foo", "Bar"} {
+		if _, err := os.Stat(filepath.Join(conf.AddonsPath, dir, dir+".toc")); err != nil {
+			t.Errorf("%v not installed: %v", dir, err)
+		}
+	}
+
+	// each entry owns only the dirs of its subdir, so neither is reinstalled
+	kinds = install(t, conf)
+	if kinds["foo"] != ActionUnchanged || kinds["bar"] != ActionUnchanged {
+		t.Errorf("second run: %v", kinds)
+	}
+
+	conf.Frozen = true
+	kinds = install(t, conf)
+	if kinds["foo"] != ActionUnchanged || kinds["bar"] != ActionUnchanged {
+		t.Errorf("frozen run: %v", kinds)
+	}
+}
+
+func TestReconcileIgnoresLockedUnlessFrozen(t *testing.T) {
+	repoDir := t.TempDir()
+	commitFiles(t, repoDir, map[string]string{
+		"Foo/Foo.toc": "## Interface: 30300\n## Title: Foo\n",
+	})
+
+	// a stale lock left on the entry must not pin a non-frozen install
+	conf := testConf(t, AddonEntry{Name: "foo", Git: repoDir, Locked: &LockEntry{Commit: strings.Repeat("0", 40)}})
+	if kinds := install(t, conf); kinds["foo"] != ActionAdd {
+		t.Fatalf("got %v", kinds)
+	}
+	if _, err := os.Stat(filepath.Join(conf.AddonsPath, "Foo", "Foo.toc")); err != nil {
+		t.Errorf("Foo not installed: %v", err)
+	}
+}
+
+// writeSource makes a local source dir with an addon dir per name
+func writeSource(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		writeAddon(t, filepath.Join(dir, name), "## Interface: 30300\n## Title: "+name+"\n")
+	}
+
+	return dir
+}
+
+func TestReconcile(t *testing.T) {
+	tests := []struct {
+		name string
+		// change is made to the config and the source after the first install
+		change   func(t *testing.T, conf *Conf, source string)
+		kind     ActionKind
+		installs []string
+		deletes  []string
+		err      string
+	}{
+		{
+			name:   "unchanged",
+			change: func(t *testing.T, conf *Conf, source string) {},
+			kind:   ActionUnchanged,
+		},
+		{
+			name: "revision update",
+			change: func(t *testing.T, conf *Conf, source string) {
+				if err := os.WriteFile(filepath.Join(source, "Foo", "Foo.lua"), []byte("-- new file"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			kind:     ActionUpdate,
+			installs: []string{"Bar", "Foo"},
+		},
+		{
+			name: "fingerprint change",
+			change: func(t *testing.T, conf *Conf, source string) {
+				conf.Addons[0].Exclude = []string{"Bar"}
+			},
+			kind:     ActionUpdate,
+			installs: []string{"Foo"},
+			deletes:  []string{"Bar"},
+		},
+		{
+			name: "orphan removal",
+			change: func(t *testing.T, conf *Conf, source string) {
+				conf.Addons = nil
+			},
+			kind:    ActionRemove,
+			deletes: []string{"Bar", "Foo"},
+		},
+		{
+			name: "orphans kept with keep unlisted",
+			change: func(t *testing.T, conf *Conf, source string) {
+				conf.Addons = nil
+				conf.KeepUnlisted = true
+			},
+		},
+		{
+			name: "duplicate folder",
+			change: func(t *testing.T, conf *Conf, source string) {
+				conf.Addons = append(conf.Addons, AddonEntry{Name: "other", Path: writeSource(t, "Foo")})
+			},
+			err: "installs Foo which another entry already installs",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := writeSource(t, "Foo", "Bar")
+			conf := testConf(t, AddonEntry{Name: "local", Path: source})
+			kinds := install(t, conf)
+			if kinds["local"] != ActionAdd {
+				t.Fatalf("first run: %v", kinds)
+			}
+			for _, dir := range []string{"Foo", "Bar"} {
+				if _, err := ReadMarker(filepath.Join(conf.AddonsPath, dir)); err != nil {
+					t.Fatalf("%v has no marker: %v", dir, err)
+				}
+			}
+
+			test.change(t, &conf, source)
+			actions, err := Reconcile(context.Background(), conf)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			installs := []string{}
+			deletes := []string{}
+			kind := ActionKind("")
+			for _, action := range actions {
+				if kind != "" && kind != action.Kind {
+					t.Fatalf("got actions %+v, want only %v", actions, test.kind)
+				}
+				kind = action.Kind
+				for _, inst := range action.Installs {
+					installs = append(installs, inst.Name)
+				}
+				for _, dir := range action.Deletes {
+					deletes = append(deletes, filepath.Base(dir))
+				}
+			}
+			slices.Sort(installs)
+			slices.Sort(deletes)
+
+			if kind != test.kind {
+				t.Errorf("got %v, want %v", kind, test.kind)
+			}
+			if strings.Join(installs, " ") != strings.Join(test.installs, " ") {
+				t.Errorf("got installs %v, want %v", installs, test.installs)
+			}
+			if strings.Join(deletes, " ") != strings.Join(test.deletes, " ") {
+				t.Errorf("got deletes %v, want %v", deletes, test.deletes)
+			}
+		})
+	}
+}
@@ -0,0 +1,459 @@
+package addons
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+)
+
+// Release is a published build of a release source, ex. a GitHub release and its assets
+type Release struct {
+	Tag string
+	// ID identifies the release where its tag may repeat, ex. the file id of a CurseForge file named like an older one
+	ID         string
+	Prerelease bool
+	Published  time.Time
+	Assets     []ReleaseAsset
+	// Channel is set by sources that publish per channel, otherwise it is read from the tag
+	Channel string
+	// Source is the archive of the tagged sources, installed when the release has no archive asset
+	Source *ReleaseAsset
+}
+
+// ReleaseAsset is a downloadable file of a release
+type ReleaseAsset struct {
+	Name string
+	URL  string
+	// Flavors are the game flavors the source says the file is for, otherwise they are read from its name
+	Flavors []string
+}
+
+// IsRelease is true for entries that install a release of an API source instead of a fixed url
+func (entry AddonEntry) IsRelease() bool {
+	return entry.GitHub != "" || entry.GitLab != "" || entry.Gitea != "" || entry.WoWInterface != "" || entry.Wago != "" || entry.CurseForge != ""
+}
+
+// releaseKey identifies a release source, ex. github:owner/repo or gitlab:https://git.example.com/group/repo
+func (entry AddonEntry) releaseKey() string {
+	switch {
+	case entry.GitHub != "":
+		return "github:" + entry.GitHub
+	case entry.GitLab != "":
+		return "gitlab:" + entry.GitLab
+	case entry.Gitea != "":
+		return "gitea:" + entry.Gitea
+	case entry.WoWInterface != "":
+		return "wowinterface:" + entry.WoWInterface
+	case entry.Wago != "":
+		return "wago:" + entry.Wago
+	}
+
+	return "curseforge:" + entry.CurseForge
+}
+
+// releaseHost is the host of a release source given as a url, otherwise its kind ex. github
+func (entry AddonEntry) releaseHost() string {
+	kind, project, _ := strings.Cut(entry.releaseKey(), ":")
+	server, _ := releaseServer(project, "")
+	if u, err := url.Parse(server); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return kind
+}
+
+// releaseServer splits a gitlab or gitea source into its server and project path. The source is a project
+// path on the config's server, ex. group/repo, or the url of a project on another server.
+func releaseServer(source, base string) (string, string) {
+	u, err := url.Parse(source)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return u.Scheme + "://" + u.Host, strings.Trim(strings.TrimSuffix(u.Path, ".git"), "/")
+	}
+
+	return base, strings.Trim(source, "/")
+}
+
+// sameServer is true when two urls have the same scheme and host, tokens are only sent to their own server
+func sameServer(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// releaseAuth are the token headers for downloading an asset of the entry's release, for private projects
+func (c Conf) releaseAuth(entry AddonEntry, assetURL string) map[string]string {
+	switch {
+	case entry.GitHub != "":
+		if sameServer(assetURL, c.githubAPI()) {
+			return c.githubAssetAuth(assetURL)
+		}
+	case entry.GitLab != "":
+		server, _ := releaseServer(entry.GitLab, c.gitlabURL())
+		if sameServer(assetURL, server) {
+			return c.gitlabAuth(server)
+		}
+	case entry.Gitea != "":
+		server, _ := releaseServer(entry.Gitea, c.giteaURL())
+		if sameServer(assetURL, server) {
+			return c.giteaAuth(server)
+		}
+	case entry.Wago != "":
+		return c.wagoAuth(assetURL)
+	}
+
+	return map[string]string{}
+}
+
+// sourceArchive is the zip of a project's sources at a tag, named like GitHub names them ex. repo-v1.2.0.zip
+func sourceArchive(project, tag, archiveURL string) *ReleaseAsset {
+	return &ReleaseAsset{
+		Name: path.Base(project) + "-" + strings.TrimPrefix(tag, "v") + ".zip",
+		URL:  archiveURL,
+	}
+}
+
+// validateRelease checks the release source options of an entry
+func (entry *AddonEntry) validateRelease() error {
+	if entry.Flavor != "" {
+		flavor, err := ParseFlavor(entry.Flavor)
+		if err != nil {
+			return fmt.Errorf("entry %v: %w", entry.SourceKey(), err)
+		}
+		entry.Flavor = flavor
+	}
+	if entry.Asset != "" {
+		_, err := filepath.Match(entry.Asset, "")
+		if err != nil {
+			return fmt.Errorf("entry %v: bad asset pattern %q: %w", entry.SourceKey(), entry.Asset, err)
+		}
+	}
+
+	sources := 0
+	for _, source := range []string{entry.GitHub, entry.GitLab, entry.Gitea, entry.WoWInterface, entry.Wago, entry.CurseForge} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("entry %v: set only one of github, gitlab, gitea, wowinterface, wago and curseforge", entry.SourceKey())
+	}
+
+	if !entry.IsRelease() {
+		if entry.Asset != "" {
+			return fmt.Errorf("entry %v: asset only applies to release sources", entry.SourceKey())
+		}
+		return nil
+	}
+	if entry.Branch != "" || entry.Commit != "" {
+		return fmt.Errorf("entry %v: branch and commit only apply to git sources, pin a release with tag", entry.SourceKey())
+	}
+
+	if entry.GitHub != "" {
+		owner, repo, ok := strings.Cut(entry.GitHub, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return fmt.Errorf("entry %v: github must be owner/repo", entry.SourceKey())
+		}
+	}
+	if entry.GitLab != "" {
+		_, project := releaseServer(entry.GitLab, "")
+		if len(strings.Split(project, "/")) < 2 || slices.Contains(strings.Split(project, "/"), "") {
+			return fmt.Errorf("entry %v: gitlab must be a project path group/repo or a project url", entry.SourceKey())
+		}
+	}
+	if entry.Gitea != "" {
+		_, repo := releaseServer(entry.Gitea, "")
+		if parts := strings.Split(repo, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("entry %v: gitea must be owner/repo or a repo url", entry.SourceKey())
+		}
+	}
+	if entry.WoWInterface != "" && strings.Trim(entry.WoWInterface, "0123456789") != "" {
+		return fmt.Errorf("entry %v: wowinterface must be the addon id, ex. \"12345\"", entry.SourceKey())
+	}
+	if entry.CurseForge != "" && strings.Trim(entry.CurseForge, "0123456789") != "" {
+		return fmt.Errorf("entry %v: curseforge must be the project id, ex. \"12345\"", entry.SourceKey())
+	}
+	if entry.Wago != "" && strings.ContainsAny(entry.Wago, "/?#") {
+		return fmt.Errorf("entry %v: wago must be the project id, ex. \"aR7m2ZKb\"", entry.SourceKey())
+	}
+
+	return nil
+}
+
+// listReleases asks the entry's source for its releases
+func listReleases(ctx context.Context, conf Conf, entry AddonEntry) ([]Release, error) {
+	switch {
+	case entry.GitHub != "":
+		return listGitHubReleases(ctx, conf, entry)
+	case entry.GitLab != "":
+		return listGitLabReleases(ctx, conf, entry)
+	case entry.Gitea != "":
+		return listGiteaReleases(ctx, conf, entry)
+	case entry.WoWInterface != "":
+		return listWoWInterfaceReleases(ctx, conf, entry)
+	case entry.Wago != "":
+		return listWagoReleases(ctx, conf, entry)
+	case entry.CurseForge != "":
+		return listCurseForgeReleases(ctx, conf, entry)
+	}
+
+	return nil, fmt.Errorf("%v is not a release source", entry.SourceKey())
+}
+
+// resolveRelease picks the entry's release and asset and points the entry's zip at it, so it is fetched
+// like any archive. The revision is the release tag and asset name, known before downloading.
+func resolveRelease(ctx context.Context, conf Conf, entry *AddonEntry) error {
+	releases, err := listReleases(ctx, conf, *entry)
+	if err != nil {
+		return err
+	}
+
+	release, err := selectRelease(*entry, releases)
+	if err != nil {
+		return err
+	}
+
+	flavor, err := conf.entryFlavor(*entry)
+	if err != nil {
+		return err
+	}
+	asset, err := selectAsset(*entry, flavor, release)
+	if err != nil {
+		return err
+	}
+	// ex. CurseForge files of projects that disallow third party downloads
+	if asset.URL == "" {
+		return fmt.Errorf("%v release %v has no download url for %v, its author may only allow downloads from the site", entry.SourceKey(), release.Tag, asset.Name)
+	}
+
+	entry.Log().Debug().Msgf("Resolved %v to release %v asset %v", entry.SourceKey(), release.Tag, asset.Name)
+	entry.Zip = asset.URL
+	entry.ResolvedTag = release.Tag
+	entry.Revision = "release:" + release.Tag + "/" + asset.Name
+	if release.ID != "" {
+		entry.ResolvedTag = release.ID
+		entry.Revision = "release:" + release.ID + "@" + release.Published.UTC().Format(time.RFC3339) + "/" + asset.Name
+	}
+
+	return nil
+}
+
+// entryFlavor is the flavor release files are picked for, the entry's or the config's
+func (c Conf) entryFlavor(entry AddonEntry) (string, error) {
+	if entry.Flavor != "" || c.Flavor == "" {
+		return entry.Flavor, nil
+	}
+
+	return ParseFlavor(c.Flavor)
+}
+
+// releaseChannel is the channel of a release, set by the source or by its tag or pre-release flag
+func releaseChannel(release Release) string {
+	if release.Channel != "" {
+		return release.Channel
+	}
+
+	channel := ChannelStable
+	if v, ok := ParseTagVersion(release.Tag); ok {
+		channel = v.Channel
+	}
+	if release.Prerelease && channel == ChannelStable {
+		channel = ChannelBeta
+	}
+
+	return channel
+}
+
+// selectRelease picks the locked or pinned release, the highest release matching the entry's version
+// constraint, or the newest release in the entry's channel
+func selectRelease(entry AddonEntry, releases []Release) (Release, error) {
+	tag := entry.Tag
+	if entry.Locked != nil && entry.Locked.Tag != "" {
+		tag = entry.Locked.Tag
+	}
+	if tag != "" {
+		for _, release := range releases {
+			if release.Tag == tag || (release.ID != "" && release.ID == tag) {
+				return release, nil
+			}
+		}
+		return Release{}, fmt.Errorf("release %v not found in %v", tag, entry.SourceKey())
+	}
+
+	channel := entry.Channel
+	if channel == "" {
+		channel = ChannelStable
+	}
+
+	candidates := []Release{}
+	for _, release := range releases {
+		if channelRank[releaseChannel(release)] <= channelRank[channel] {
+			candidates = append(candidates, release)
+		}
+	}
+
+	if entry.Version == "" {
+		if len(candidates) == 0 {
+			return Release{}, fmt.Errorf("no release of %v in the %v channel", entry.SourceKey(), channel)
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Published.After(candidates[j].Published)
+		})
+		return candidates[0], nil
+	}
+
+	tags := []string{}
+	byTag := map[string]Release{}
+	for _, release := range candidates {
+		// releases are newest first, the newest of releases with the same tag is kept
+		if _, ok := byTag[release.Tag]; ok {
+			continue
+		}
+		tags = append(tags, release.Tag)
+		byTag[release.Tag] = release
+	}
+
+	// the channel was already applied with the source's pre-release flag
+	alpha := entry
+	alpha.Channel = ChannelAlpha
+	best, err := selectTag(alpha, tags)
+	if err != nil {
+		return Release{}, fmt.Errorf("no release of %v satisfies version %q in the %v channel", entry.SourceKey(), entry.Version, channel)
+	}
+
+	return byTag[best], nil
+}
+
+// selectAsset picks the archive of a release matching the entry's asset pattern, or made for the flavor.
+// Without a flavor, assets that name no flavor are preferred, nolib builds are only taken if there is nothing else.
+func selectAsset(entry AddonEntry, flavor string, release Release) (ReleaseAsset, error) {
+	names := []string{}
+	candidates := []ReleaseAsset{}
+	for _, asset := range release.Assets {
+		names = append(names, asset.Name)
+		if entry.Asset != "" {
+			if ok, _ := filepath.Match(entry.Asset, asset.Name); ok {
+				candidates = append(candidates, asset)
+			}
+			continue
+		}
+		if util.ArchiveFormatFromName(asset.Name) != "" {
+			candidates = append(candidates, asset)
+		}
+	}
+
+	// releases without archive assets install the source archive of their tag
+	if len(candidates) == 0 && entry.Asset == "" && release.Source != nil {
+		return *release.Source, nil
+	}
+
+	// narrow down in steps, keeping the previous candidates when a step would leave none
+	narrow := func(keep func(ReleaseAsset) bool) {
+		kept := slices.DeleteFunc(slices.Clone(candidates), func(a ReleaseAsset) bool { return !keep(a) })
+		if len(kept) > 0 {
+			candidates = kept
+		}
+	}
+
+	flavorsOf := func(a ReleaseAsset) []string {
+		if len(a.Flavors) > 0 {
+			return a.Flavors
+		}
+		return nameFlavors(a.Name)
+	}
+	if flavor != "" {
+		matching := slices.DeleteFunc(slices.Clone(candidates), func(a ReleaseAsset) bool {
+			flavors := flavorsOf(a)
+			return len(flavors) > 0 && !slices.Contains(flavors, flavor)
+		})
+		if len(matching) == 0 && len(candidates) > 0 {
+			return ReleaseAsset{}, fmt.Errorf("no asset of %v release %v is for %v: %v", entry.SourceKey(), release.Tag, flavor, names)
+		}
+		candidates = matching
+		narrow(func(a ReleaseAsset) bool { return slices.Contains(flavorsOf(a), flavor) })
+	} else {
+		narrow(func(a ReleaseAsset) bool { return len(flavorsOf(a)) == 0 })
+	}
+	narrow(func(a ReleaseAsset) bool { return !strings.Contains(strings.ToLower(a.Name), "nolib") })
+
+	switch len(candidates) {
+	case 0:
+		return ReleaseAsset{}, fmt.Errorf("no asset of %v release %v matches, it has %v", entry.SourceKey(), release.Tag, names)
+	case 1:
+		return candidates[0], nil
+	}
+
+	return ReleaseAsset{}, fmt.Errorf("several assets of %v release %v match %v, pick one with asset = or flavor =", entry.SourceKey(), release.Tag, assetNames(candidates))
+}
+
+func assetNames(assets []ReleaseAsset) []string {
+	names := []string{}
+	for _, a := range assets {
+		names = append(names, a.Name)
+	}
+
+	return names
+}
+
+// getJSON fetches an API url into out
+func getJSON(ctx context.Context, apiURL string, headers map[string]string, out any) error {
+	client := http.Client{
+		Timeout: time.Second * 20,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "wow-addon-cli/"+Version)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status from %v: %v %s", redactQuery(apiURL), resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(out)
+	if err != nil {
+		return fmt.Errorf("error reading response from %v: %w", redactQuery(apiURL), err)
+	}
+
+	return nil
+}
+
+// redactQuery drops the query of a url for errors and logs, some APIs take keys in it
+func redactQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.RawQuery = ""
+
+	return u.String()
+}
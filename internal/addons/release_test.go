@@ -0,0 +1,205 @@
+package addons
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const githubReleasesJSON = `[
+  {"tag_name": "v2.1.0-beta1", "prerelease": true, "published_at": "2024-03-01T00:00:00Z",
+   "zipball_url": "%[1]s/repos/owner/Addon/zipball/v2.1.0-beta1",
+   "assets": [{"name": "Addon-v2.1.0-beta1.zip", "url": "%[1]s/repos/owner/Addon/releases/assets/4", "browser_download_url": "%[1]s/dl/Addon-v2.1.0-beta1.zip"}]},
+  {"tag_name": "v3.0.0", "draft": true, "published_at": "2024-04-01T00:00:00Z",
+   "assets": [{"name": "Addon-v3.0.0.zip", "url": "%[1]s/repos/owner/Addon/releases/assets/5", "browser_download_url": "%[1]s/dl/Addon-v3.0.0.zip"}]},
+  {"tag_name": "v2.0.0", "published_at": "2024-02-01T00:00:00Z",
+   "zipball_url": "%[1]s/repos/owner/Addon/zipball/v2.0.0",
+   "assets": [
+     {"name": "Addon-v2.0.0.zip", "url": "%[1]s/repos/owner/Addon/releases/assets/1", "browser_download_url": "%[1]s/dl/Addon-v2.0.0.zip"},
+     {"name": "Addon-v2.0.0-wrath.zip", "url": "%[1]s/repos/owner/Addon/releases/assets/2", "browser_download_url": "%[1]s/dl/Addon-v2.0.0-wrath.zip"},
+     {"name": "Addon-v2.0.0-nolib.zip", "url": "%[1]s/repos/owner/Addon/releases/assets/3", "browser_download_url": "%[1]s/dl/Addon-v2.0.0-nolib.zip"}
+   ]},
+  {"tag_name": "v1.0.0", "published_at": "2024-01-01T00:00:00Z",
+   "zipball_url": "%[1]s/repos/owner/Addon/zipball/v1.0.0",
+   "assets": []}
+]`
+
+// githubServer stands in for the GitHub API, it records the Authorization header of each request
+func githubServer(t *testing.T) (*httptest.Server, *[]string) {
+	t.Helper()
+	auth := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		if r.URL.Path != "/repos/owner/Addon/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.ReplaceAll(githubReleasesJSON, "%[1]s", server.URL)))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &auth
+}
+
+func TestListGitHubReleases(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, auth := githubServer(t)
+	conf := Conf{GitHubAPI: server.URL}
+	entry := AddonEntry{GitHub: "owner/Addon"}
+
+	releases, err := listGitHubReleases(context.Background(), conf, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := []string{}
+	for _, release := range releases {
+		tags = append(tags, release.Tag)
+	}
+	if strings.Join(tags, " ") != "v2.1.0-beta1 v2.0.0 v1.0.0" {
+		t.Fatalf("got releases %v, the draft should be left out", tags)
+	}
+	if !releases[0].Prerelease || releases[1].Prerelease {
+		t.Errorf("pre-release flags not kept: %+v", releases[:2])
+	}
+	if got := releases[1].Assets[0].URL; got != server.URL+"/dl/Addon-v2.0.0.zip" {
+		t.Errorf("without a token assets should use their browser download url, got %v", got)
+	}
+	if got := releases[2].Source; got == nil || got.Name != "Addon-1.0.0.zip" {
+		t.Errorf("got source archive %+v, want Addon-1.0.0.zip", got)
+	}
+	if (*auth)[0] != "" {
+		t.Errorf("sent Authorization %q without a token", (*auth)[0])
+	}
+}
+
+func TestListGitHubReleasesToken(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, auth := githubServer(t)
+	conf := Conf{GitHubAPI: server.URL, GitHubToken: "secret"}
+	entry := AddonEntry{GitHub: "owner/Addon"}
+
+	releases, err := listGitHubReleases(context.Background(), conf, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*auth)[0] != "Bearer secret" {
+		t.Errorf("got Authorization %q, want the token", (*auth)[0])
+	}
+
+	assetURL := releases[1].Assets[0].URL
+	if assetURL != server.URL+"/repos/owner/Addon/releases/assets/1" {
+		t.Fatalf("with a token assets should use their API url, got %v", assetURL)
+	}
+	headers := conf.releaseAuth(entry, assetURL)
+	if headers["Authorization"] != "Bearer secret" || headers["Accept"] != "application/octet-stream" {
+		t.Errorf("got asset download headers %v", headers)
+	}
+
+	headers = conf.releaseAuth(entry, releases[2].Source.URL)
+	if headers["Authorization"] != "Bearer secret" || headers["Accept"] != "" {
+		t.Errorf("got source archive download headers %v", headers)
+	}
+
+	// the token is only sent to the configured API
+	if headers := conf.releaseAuth(entry, "https://example.com/Addon.zip"); len(headers) != 0 {
+		t.Errorf("sent %v to another server", headers)
+	}
+}
+
+func TestResolveRelease(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "")
+	server, _ := githubServer(t)
+
+	tests := []struct {
+		name     string
+		entry    AddonEntry
+		flavor   string
+		revision string
+		err      string
+	}{
+		{name: "stable skips pre-releases", entry: AddonEntry{}, revision: "release:v2.0.0/Addon-v2.0.0.zip"},
+		{name: "beta takes the newest pre-release", entry: AddonEntry{Channel: ChannelBeta}, revision: "release:v2.1.0-beta1/Addon-v2.1.0-beta1.zip"},
+		{name: "flavor", entry: AddonEntry{Flavor: FlavorWrath}, revision: "release:v2.0.0/Addon-v2.0.0-wrath.zip"},
+		{name: "config flavor", flavor: "wotlk", revision: "release:v2.0.0/Addon-v2.0.0-wrath.zip"},
+		{name: "flavor without its own asset", entry: AddonEntry{Flavor: FlavorCata}, revision: "release:v2.0.0/Addon-v2.0.0.zip"},
+		{name: "pattern", entry: AddonEntry{Asset: "*-nolib.zip"}, revision: "release:v2.0.0/Addon-v2.0.0-nolib.zip"},
+		{name: "pattern without a match", entry: AddonEntry{Asset: "*-tbc.zip"}, err: "no asset of github:owner/Addon release v2.0.0 matches"},
+		{name: "tag", entry: AddonEntry{Tag: "v1.0.0"}, revision: "release:v1.0.0/Addon-1.0.0.zip"},
+		{name: "missing tag", entry: AddonEntry{Tag: "v9.0.0"}, err: "release v9.0.0 not found"},
+		{name: "version", entry: AddonEntry{Version: "<2.0.0"}, revision: "release:v1.0.0/Addon-1.0.0.zip"},
+		{name: "version without a match", entry: AddonEntry{Version: ">=3.0.0"}, err: "no release of github:owner/Addon satisfies"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := Conf{GitHubAPI: server.URL, Flavor: test.flavor}
+			entry := test.entry
+			entry.GitHub = "owner/Addon"
+
+			err := resolveRelease(context.Background(), conf, &entry)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Revision != test.revision {
+				t.Errorf("got revision %v, want %v", entry.Revision, test.revision)
+			}
+		})
+	}
+}
+
+func TestSelectAsset(t *testing.T) {
+	release := Release{
+		Tag: "v1.0.0",
+		Assets: []ReleaseAsset{
+			{Name: "Addon-1.0.0-classic.zip"},
+			{Name: "Addon-1.0.0-wrath.zip"},
+			{Name: "Addon-1.0.0-tbc.zip", Flavors: []string{FlavorTBC}},
+			{Name: "checksums.txt"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		entry  AddonEntry
+		flavor string
+		want   string
+		err    string
+	}{
+		{name: "flavor alias in the name", flavor: FlavorVanilla, want: "Addon-1.0.0-classic.zip"},
+		{name: "flavor from the source", flavor: FlavorTBC, want: "Addon-1.0.0-tbc.zip"},
+		{name: "no asset for the flavor", flavor: FlavorRetail, err: "is for retail"},
+		{name: "several matches", err: "several assets"},
+		{name: "pattern", entry: AddonEntry{Asset: "*-wrath.zip"}, want: "Addon-1.0.0-wrath.zip"},
+		{name: "pattern over flavor", entry: AddonEntry{Asset: "*-wrath.zip"}, flavor: FlavorVanilla, err: "is for vanilla"},
+		{name: "pattern without a match", entry: AddonEntry{Asset: "*.tar.gz"}, err: "matches, it has"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			asset, err := selectAsset(test.entry, test.flavor, release)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got asset %v error %v, want %q", asset.Name, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if asset.Name != test.want {
+				t.Errorf("got %v, want %v", asset.Name, test.want)
+			}
+		})
+	}
+}
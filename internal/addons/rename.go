@@ -0,0 +1,79 @@
+package addons
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+)
+
+// validateRename checks the entry's rename targets are usable addon dir names
+func (entry AddonEntry) validateRename() error {
+	for from, to := range entry.Rename {
+		if to == "" || to != filepath.Base(to) || strings.HasPrefix(to, ".") {
+			return fmt.Errorf("entry %v: cannot rename %v to %q, it must be a plain folder name", entry.SourceKey(), from, to)
+		}
+	}
+
+	return nil
+}
+
+// renameInstalls installs the addon dirs named in the entry's rename map under their new name.
+// The TOC files in the download are renamed too, WoW only loads an addon whose TOC matches its dir,
+// ex. pfQuest-epoch/pfQuest-epoch_Wrath.toc -> pfQuest/pfQuest_Wrath.toc
+func renameInstalls(entry AddonEntry, installs []AddonInstall) ([]AddonInstall, error) {
+	renamed := map[string]bool{}
+	names := installNames(installs)
+
+	for i, inst := range installs {
+		to, ok := entry.Rename[inst.Name]
+		if !ok {
+			continue
+		}
+		renamed[inst.Name] = true
+
+		tocs, err := filepath.Glob(filepath.Join(inst.SrcDir, "*.toc"))
+		if err != nil {
+			return installs, err
+		}
+		for _, toc := range tocs {
+			base := util.RemoveExt(filepath.Base(toc))
+			rest, ok := strings.CutPrefix(base, inst.Name)
+			if !ok || (rest != "" && !strings.HasPrefix(rest, "_") && !strings.HasPrefix(rest, "-")) {
+				continue
+			}
+
+			dest := filepath.Join(inst.SrcDir, to+rest+filepath.Ext(toc))
+			entry.Log().Debug().Msgf("Renaming %v to %v", toc, dest)
+			err = os.Rename(toc, dest)
+			if err != nil {
+				return installs, err
+			}
+		}
+
+		entry.Log().Info().Msgf("Installing %v as %v", inst.Name, to)
+		installs[i].Name = to
+	}
+
+	for from := range entry.Rename {
+		if !renamed[from] {
+			entry.Log().Warn().Msgf("rename of %v matches none of the addons %v", from, names)
+		}
+	}
+
+	return installs, nil
+}
+
+// renameFingerprint is the rename map in a stable order, ex. "pfQuest-epoch>pfQuest"
+func (entry AddonEntry) renameFingerprint() string {
+	pairs := []string{}
+	for from, to := range entry.Rename {
+		pairs = append(pairs, from+">"+to)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
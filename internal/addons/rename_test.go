@@ -0,0 +1,111 @@
+package addons
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRenameInstalls(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  []string
+		rename map[string]string
+		names  []string
+		// want are the files of pfQuest-epoch's dir after renaming
+		want []string
+	}{
+		{
+			name:   "toc and flavor tocs",
+			files:  []string{"pfQuest-epoch.toc", "pfQuest-epoch_Wrath.toc", "pfQuest-epoch-Classic.toc", "init.lua"},
+			rename: map[string]string{"pfQuest-epoch": "pfQuest"},
+			names:  []string{"pfQuest", "pfQuest-data"},
+			want:   []string{"init.lua", "pfQuest-Classic.toc", "pfQuest.toc", "pfQuest_Wrath.toc"},
+		},
+		{
+			name:   "tocs of other addons are kept",
+			files:  []string{"pfQuest-epoch.toc", "pfQuest-epochs.toc", "Other_Wrath.toc"},
+			rename: map[string]string{"pfQuest-epoch": "pfQuest"},
+			names:  []string{"pfQuest", "pfQuest-data"},
+			want:   []string{"Other_Wrath.toc", "pfQuest-epochs.toc", "pfQuest.toc"},
+		},
+		{
+			name:   "rename matching no addon",
+			files:  []string{"pfQuest-epoch.toc"},
+			rename: map[string]string{"Missing": "Other"},
+			names:  []string{"pfQuest-epoch", "pfQuest-data"},
+			want:   []string{"pfQuest-epoch.toc"},
+		},
+		{
+			name:  "no rename",
+			files: []string{"pfQuest-epoch_Wrath.toc"},
+			names: []string{"pfQuest-epoch", "pfQuest-data"},
+			want:  []string{"pfQuest-epoch_Wrath.toc"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			root := t.TempDir()
+			installs := []AddonInstall{}
+			for _, name := range []string{"pfQuest-epoch", "pfQuest-data"} {
+				installs = append(installs, AddonInstall{Name: name, SrcDir: filepath.Join(root, name)})
+				if err := os.MkdirAll(filepath.Join(root, name), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, file := range test.files {
+				if err := os.WriteFile(filepath.Join(root, "pfQuest-epoch", file), []byte("## Interface: 30300\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			entry := AddonEntry{Git: "https://example.com/pfQuest.git", Rename: test.rename}
+			installs, err := renameInstalls(entry, installs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(installNames(installs), test.names) {
+				t.Errorf("got names %v, want %v", installNames(installs), test.names)
+			}
+			if installs[0].SrcDir != filepath.Join(root, "pfQuest-epoch") {
+				t.Errorf("source dir changed to %v", installs[0].SrcDir)
+			}
+
+			entries, err := os.ReadDir(filepath.Join(root, "pfQuest-epoch"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			files := []string{}
+			for _, e := range entries {
+				files = append(files, e.Name())
+			}
+			if !slices.Equal(files, test.want) {
+				t.Errorf("got files %v, want %v", files, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateRename(t *testing.T) {
+	tests := map[string]bool{
+		"pfQuest":     true,
+		"pfQuest_New": true,
+		"":            false,
+		"../pfQuest":  false,
+		"sub/pfQuest": false,
+		".pfQuest":    false,
+	}
+
+	for to, ok := range tests {
+		err := AddonEntry{Rename: map[string]string{"pfQuest-epoch": to}}.validateRename()
+		if ok && err != nil {
+			t.Errorf("%q refused: %v", to, err)
+		}
+		if !ok && (err == nil || !strings.Contains(err.Error(), "plain folder name")) {
+			t.Errorf("%q: got %v, want it refused", to, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+type EntryState string
+
+const (
+	StateNotInstalled    EntryState = "not installed"
+	StateUpToDate        EntryState = "up to date"
+	StateUpdateAvailable EntryState = "update available"
+	StateUnknown         EntryState = "unknown"
+	StateNotInConfig     EntryState = "not in config"
+)
+
+// EntryStatus describes a config entry, or a managed dir no entry installs, compared to its source
+type EntryStatus struct {
+	Entry             AddonEntry
+	State             EntryState
+	Dirs              []string
+	InstalledRevision string
+	RemoteRevision    string
+	// Modified are files changed since install, ex. Bagnon/Bagnon.lua
+	Modified []string
+}
+
+// matchesInstalled is true when name refers to an installed dir, by dir name, entry name or source.
+// Names are matched case insensitively.
+func matchesInstalled(name string, dir string, marker *Marker) bool {
+	return strings.EqualFold(filepath.Base(dir), name) || (marker.Entry != "" && strings.EqualFold(marker.Entry, name)) || (marker.Source != "" && marker.Source == name)
+}
+
+// SelectEntries narrows the config to the entries matching names, by entry name, source
+// or the name of an addon dir the entry installed
+func SelectEntries(conf Conf, names []string) ([]AddonEntry, error) {
+	installed, err := ListInstalled(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []AddonEntry{}
+	for _, name := range names {
+		found := false
+		for _, entry := range conf.Addons {
+			hydrated := entry
+			err := hydrated.Hydrate()
+			if err != nil {
+				return nil, fmt.Errorf("error hydrating entry %+v: %w", entry, err)
+			}
+
+			matches := strings.EqualFold(name, hydrated.DisplayName()) || name == hydrated.SourceKey()
+			for _, dir := range installedDirsForEntry(installed, hydrated) {
+				if strings.EqualFold(filepath.Base(dir), name) {
+					matches = true
+				}
+			}
+
+			if matches {
+				selected = append(selected, entry)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("no config entry matches %q", name)
+		}
+	}
+
+	return selected, nil
+}
+
+// PlanRemove plans removing installed addon dirs by dir name, entry name or source
+func PlanRemove(conf Conf, names []string) (*Plan, error) {
+	plan := &Plan{
+		AddonsPath: conf.AddonsPath,
+	}
+
+	installed, err := ListInstalled(conf)
+	if err != nil {
+		return plan, err
+	}
+
+	configured := map[string]bool{}
+	for _, entry := range conf.Addons {
+		err := entry.Hydrate()
+		if err != nil {
+			return plan, fmt.Errorf("error hydrating entry %+v: %w", entry, err)
+		}
+		configured[entry.SourceKey()] = true
+	}
+
+	dirs := []string{}
+	for dir := range installed {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, name := range names {
+		found := false
+		for _, dir := range dirs {
+			marker := installed[dir]
+			if !matchesInstalled(name, dir, marker) {
+				continue
+			}
+			found = true
+
+			if configured[marker.Source] {
+				log.Warn().Msgf("%v is still in the config and will be installed again on the next install", filepath.Base(dir))
+			}
+
+			plan.Actions = append(plan.Actions, Action{
+				Kind:    ActionRemove,
+				Dirs:    []string{dir},
+				Deletes: []string{dir},
+			})
+		}
+
+		if !found {
+			return plan, fmt.Errorf("no installed addon matches %q", name)
+		}
+	}
+
+	return plan, nil
+}
+
+// Status compares each config entry with what is installed and the current remote revision
+func Status(conf Conf) ([]EntryStatus, error) {
+	statuses := []EntryStatus{}
+
+	installed, err := ListInstalled(conf)
+	if err != nil {
+		return statuses, err
+	}
+
+	claimed := map[string]bool{}
+	for _, entry := range conf.Addons {
+		err := entry.Hydrate()
+		if err != nil {
+			return statuses, fmt.Errorf("error hydrating entry %+v: %w", entry, err)
+		}
+
+		status := EntryStatus{
+			Entry: entry,
+			State: StateNotInstalled,
+			Dirs:  installedDirsForEntry(installed, entry),
+		}
+		sort.Strings(status.Dirs)
+
+		if len(status.Dirs) > 0 {
+			status.InstalledRevision = installed[status.Dirs[0]].Revision
+			status.State = StateUnknown
+
+			status.RemoteRevision, err = RemoteRevision(context.Background(), conf, entry)
+			if err != nil {
+				log.Warn().Err(err).Msgf("could not resolve remote revision of %v", entry.SourceKey())
+			}
+			if status.RemoteRevision != "" {
+				status.State = StateUpdateAvailable
+				if isInstalledRevision(installed, entry, status.Dirs, status.RemoteRevision) {
+					status.State = StateUpToDate
+				}
+			}
+		}
+
+		for _, dir := range status.Dirs {
+			claimed[dir] = true
+			status.Modified = append(status.Modified, modifiedFiles(dir, installed[dir])...)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	dirs := []string{}
+	for dir := range installed {
+		if !claimed[dir] {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		statuses = append(statuses, EntryStatus{
+			Entry:             AddonEntry{Name: filepath.Base(dir)},
+			State:             StateNotInConfig,
+			Dirs:              []string{dir},
+			InstalledRevision: installed[dir].Revision,
+			Modified:          modifiedFiles(dir, installed[dir]),
+		})
+	}
+
+	return statuses, nil
+}
+
+func modifiedFiles(dir string, marker *Marker) []string {
+	modified := []string{}
+
+	// markers from older versions have no file hashes to compare
+	if len(marker.Files) == 0 {
+		return modified
+	}
+
+	changed, err := marker.ChangedFiles(dir)
+	if err != nil {
+		log.Warn().Err(err).Msgf("could not check %v for modified files", dir)
+		return modified
+	}
+
+	for _, path := range changed {
+		modified = append(modified, filepath.Base(dir)+"/"+path)
+	}
+
+	return modified
+}
@@ -0,0 +1,262 @@
+package addons
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/config"
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+// submodules of submodules are followed this deep
+const MAX_SUBMODULE_DEPTH = 8
+
+// commitRefPrefix keeps the commits a shallow submodule mirror fetched by hash
+const commitRefPrefix = "refs/wow-addon-cli/commits/"
+
+// FetchSubmodules is whether a git entry's submodules are checked out, they are unless submodules = false
+func (entry AddonEntry) FetchSubmodules() bool {
+	return entry.Submodules == nil || *entry.Submodules
+}
+
+// exportSubmodules checks out the submodules of a commit into dest, recursively.
+// Each submodule repo gets its own mirror, like a config entry.
+func exportSubmodules(ctx context.Context, conf Conf, entry AddonEntry, modules *config.Modules, repoURL string, dest string, links []gitlink, depth int) error {
+	if len(links) == 0 {
+		return nil
+	}
+	if depth >= MAX_SUBMODULE_DEPTH {
+		return fmt.Errorf("submodules of %v nest deeper than %d", entry.Git, MAX_SUBMODULE_DEPTH)
+	}
+
+	byPath := map[string]*config.Submodule{}
+	for _, sm := range modules.Submodules {
+		byPath[path.Clean(sm.Path)] = sm
+	}
+
+	for _, link := range links {
+		sm, ok := byPath[link.Path]
+		if !ok || sm.URL == "" {
+			return fmt.Errorf("submodule %v of %v has no url in .gitmodules", link.Path, repoURL)
+		}
+
+		subURL, err := resolveSubmoduleURL(repoURL, sm.URL)
+		if err != nil {
+			return fmt.Errorf("submodule %v of %v: %w", link.Path, repoURL, err)
+		}
+
+		subDest := filepath.Join(dest, filepath.FromSlash(link.Path))
+		err = exportSubmodule(ctx, conf, entry, subURL, link, subDest, depth)
+		if err != nil {
+			return fmt.Errorf("submodule %v of %v from %v: %w (set submodules = false on the entry to skip submodules)", link.Path, repoURL, subURL, err)
+		}
+	}
+
+	return nil
+}
+
+func exportSubmodule(ctx context.Context, conf Conf, entry AddonEntry, subURL string, link gitlink, dest string, depth int) error {
+	// the submodule is logged and downloaded as part of its entry
+	sub := AddonEntry{
+		Git:        subURL,
+		Name:       entry.DisplayName(),
+		UniqueName: entry.UniqueName,
+	}
+
+	mirrorPath, err := conf.MirrorPath(sub)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockMirror(mirrorPath)
+	if err != nil {
+		return err
+	}
+	unlock = sync.OnceFunc(unlock)
+	defer unlock()
+
+	entry.Log().Info().Msgf("Checking out submodule %v at %v", subURL, link.Hash)
+	repo, err := submoduleMirror(ctx, conf, sub, mirrorPath, link.Hash)
+	if err != nil {
+		return fmt.Errorf("commit %v: %w", link.Hash, err)
+	}
+
+	links, err := exportCommit(ctx, conf, repo, link.Hash, dest, "")
+	if err != nil {
+		return err
+	}
+
+	err = cacheMirror(conf, sub, repo, mirrorPath)
+	if err != nil {
+		return err
+	}
+
+	modules, err := readSubmodules(repo, link.Hash, subURL, links)
+	if err != nil {
+		return err
+	}
+
+	// a submodule's submodules may be in this repo again, ex. a library and its test harness
+	unlock()
+
+	return exportSubmodules(ctx, conf, entry, modules, subURL, dest, links, depth+1)
+}
+
+// submoduleMirror opens the mirror of a submodule repo that has the pinned commit. A mirror that has it
+// already is not fetched. Otherwise only that commit is fetched (a shallow mirror),
+// servers that don't send commits by hash get a full mirror.
+func submoduleMirror(ctx context.Context, conf Conf, sub AddonEntry, mirrorPath string, hash plumbing.Hash) (*git.Repository, error) {
+	// a mirror that can't be opened is fetched again
+	repo, err := git.PlainOpen(mirrorPath)
+	if err != nil {
+		repo = nil
+	} else if _, err := repo.CommitObject(hash); err == nil {
+		return repo, nil
+	}
+
+	if conf.Offline {
+		return nil, fmt.Errorf("offline and %v is not in the cache", sub.Git)
+	}
+
+	// a full mirror is kept up to date like an entry's
+	if repo != nil && !isShallowMirror(repo) {
+		repo, err = syncMirror(ctx, conf, sub, mirrorPath)
+		if err != nil {
+			return nil, err
+		}
+		_, err = repo.CommitObject(hash)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			sub.Log().Warn().Msgf("commit %v not in mirror %v, cloning again", hash, mirrorPath)
+			repo, err = cloneMirror(ctx, sub, mirrorPath, false)
+			if err != nil {
+				return nil, err
+			}
+			_, err = repo.CommitObject(hash)
+		}
+		return repo, err
+	}
+
+	repo, err = fetchCommit(ctx, sub, repo, mirrorPath, hash)
+	if errors.Is(err, git.ErrExactSHA1NotSupported) {
+		sub.Log().Debug().Msgf("%v does not send commits by hash, cloning all of it", sub.Git)
+		repo, err = cloneMirror(ctx, sub, mirrorPath, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = repo.CommitObject(hash)
+	return repo, err
+}
+
+// fetchCommit fetches a single commit and its tree into a shallow mirror, creating the mirror when repo is nil.
+// The commit is kept under commitRefPrefix.
+func fetchCommit(ctx context.Context, sub AddonEntry, repo *git.Repository, mirrorPath string, hash plumbing.Hash) (*git.Repository, error) {
+	if repo == nil {
+		err := os.RemoveAll(mirrorPath)
+		if err != nil {
+			return nil, err
+		}
+		repo, err = git.PlainInit(mirrorPath, true)
+		if err != nil {
+			return nil, err
+		}
+		_, err = repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{sub.Git}})
+		if err != nil {
+			os.RemoveAll(mirrorPath)
+			return nil, err
+		}
+	}
+
+	sub.Log().Debug().Msgf("Fetching commit %v of %v into mirror %v", hash, sub.Git, mirrorPath)
+	err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(hash.String() + ":" + commitRefPrefix + hash.String())},
+		Depth:      1,
+		Tags:       git.NoTags,
+		Progress:   new(strings.Builder),
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return repo, err
+	}
+
+	return repo, nil
+}
+
+// isShallowMirror is whether a mirror only has the commits of submodules fetched by hash,
+// without branches it has no HEAD
+func isShallowMirror(repo *git.Repository) bool {
+	_, err := repo.Head()
+	return errors.Is(err, plumbing.ErrReferenceNotFound)
+}
+
+// readSubmodules reads the .gitmodules of a commit whose submodules are checked out, nil without links
+func readSubmodules(repo *git.Repository, hash plumbing.Hash, repoURL string, links []gitlink) (*config.Modules, error) {
+	if len(links) == 0 {
+		return nil, nil
+	}
+
+	modules, err := readGitmodules(repo, hash)
+	if err != nil {
+		return nil, fmt.Errorf("%v has submodules: %w", repoURL, err)
+	}
+
+	return modules, nil
+}
+
+func readGitmodules(repo *git.Repository, hash plumbing.Hash) (*config.Modules, error) {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := commit.File(".gitmodules")
+	if err != nil {
+		return nil, fmt.Errorf("reading .gitmodules: %w", err)
+	}
+
+	contents, err := f.Contents()
+	if err != nil {
+		return nil, err
+	}
+
+	modules := config.NewModules()
+	err = modules.Unmarshal([]byte(contents))
+	if err != nil {
+		return nil, fmt.Errorf("reading .gitmodules: %w", err)
+	}
+
+	return modules, nil
+}
+
+// resolveSubmoduleURL resolves a relative submodule url like ../LibStub.git against the parent repo url,
+// the way git does
+func resolveSubmoduleURL(parentURL string, subURL string) (string, error) {
+	if !strings.HasPrefix(subURL, "./") && !strings.HasPrefix(subURL, "../") {
+		return subURL, nil
+	}
+
+	// scp like, ex. git@github.com:owner/repo.git
+	if !strings.Contains(parentURL, "://") {
+		if host, repoPath, ok := strings.Cut(parentURL, ":"); ok && !filepath.IsAbs(parentURL) {
+			return host + ":" + path.Join(repoPath, subURL), nil
+		}
+		return path.Join(parentURL, subURL), nil
+	}
+
+	u, err := url.Parse(parentURL)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve relative url %v against %v: %w", subURL, parentURL, err)
+	}
+	u.Path = path.Join(u.Path, subURL)
+
+	return u.String(), nil
+}
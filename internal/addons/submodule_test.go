@@ -0,0 +1,168 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// commitSubmodule commits a submodule at subPath of the repo in dir, pinned to hash of the repo at url
+func commitSubmodule(t *testing.T, repo *git.Repository, dir, subPath, url string, hash plumbing.Hash) plumbing.Hash {
+	t.Helper()
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gitmodules := fmt.Sprintf("[submodule %q]\n\tpath = %s\n\turl = %s\n", subPath, subPath, url)
+	if err := os.WriteFile(filepath.Join(dir, ".gitmodules"), []byte(gitmodules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add(".gitmodules"); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := idx.Add(subPath)
+	e.Mode = filemode.Submodule
+	e.Hash = hash
+	if err := repo.Storer.SetIndex(idx); err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := wt.Commit("submodule", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return commit
+}
+
+func TestSubmoduleMirror(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		shallow bool
+	}{
+		{name: "only the pinned commit", options: []string{"uploadpack.allowAnySHA1InWant"}, shallow: true},
+		{name: "server without commits by hash", options: nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the pinned commit is between an older and a newer one
+			libDir := filepath.Join(t.TempDir(), "Lib")
+			lib, older := commitFiles(t, libDir, map[string]string{"Lib.lua": "-- older"})
+			commits := []plumbing.Hash{older}
+			for _, content := range []string{"-- pinned", "-- newer"} {
+				if err := os.WriteFile(filepath.Join(libDir, "Lib.lua"), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				wt, _ := lib.Worktree()
+				if _, err := wt.Add("Lib.lua"); err != nil {
+					t.Fatal(err)
+				}
+				commit, err := wt.Commit(content, &git.CommitOptions{
+					Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				commits = append(commits, commit)
+			}
+			pinned, newer := commits[1], commits[2]
+			libURL := gitServer(t, libDir, test.options...)
+
+			addonDir := t.TempDir()
+			addon, _ := commitFiles(t, addonDir, map[string]string{"Foo/Foo.toc": "## Interface: 30300\n"})
+			commitSubmodule(t, addon, addonDir, "Foo/Libs/Lib", libURL, pinned)
+
+			conf := Conf{CachePath: t.TempDir()}
+			entry := AddonEntry{Git: addonDir}
+			downloadDir := t.TempDir()
+			if err := fetchGit(context.Background(), conf, &entry, downloadDir); err != nil {
+				t.Fatal(err)
+			}
+
+			lua, err := os.ReadFile(filepath.Join(downloadDir, entry.CloneSubdirName(), "Foo", "Libs", "Lib", "Lib.lua"))
+			if err != nil || string(lua) != "-- pinned" {
+				t.Fatalf("got submodule file %q %v, want the pinned commit", lua, err)
+			}
+
+			mirrorPath, err := conf.MirrorPath(AddonEntry{Git: libURL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mirror, err := git.PlainOpen(mirrorPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := isShallowMirror(mirror); got != test.shallow {
+				t.Errorf("got a shallow mirror %v, want %v", got, test.shallow)
+			}
+			for _, commit := range []plumbing.Hash{older, newer} {
+				_, err = mirror.CommitObject(commit)
+				if test.shallow && err == nil {
+					t.Errorf("the shallow mirror fetched %v, not only the pinned commit", commit)
+				}
+				if !test.shallow && err != nil {
+					t.Errorf("the full mirror is missing %v: %v", commit, err)
+				}
+			}
+
+			// an entry of the submodule's repo needs its branches, the shallow mirror is cloned in full
+			libEntry := AddonEntry{Git: libURL}
+			if err := fetchGit(context.Background(), conf, &libEntry, t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			if libEntry.Revision != newer.String() {
+				t.Errorf("got revision %v, want the newest commit %v", libEntry.Revision, newer)
+			}
+		})
+	}
+}
+
+func TestSubmoduleCycle(t *testing.T) {
+	// Foo pins Lib, which pins an older commit of Foo, so Foo's mirror is used again while checking out Lib
+	fooDir := t.TempDir()
+	foo, older := commitFiles(t, fooDir, map[string]string{"Foo/Foo.toc": "## Interface: 30300\n"})
+	libDir := t.TempDir()
+	lib, _ := commitFiles(t, libDir, map[string]string{"Lib.lua": "-- lib"})
+	libCommit := commitSubmodule(t, lib, libDir, "harness", fooDir, older)
+	commitSubmodule(t, foo, fooDir, "Foo/Libs/Lib", libDir, libCommit)
+
+	conf := Conf{CachePath: t.TempDir()}
+	entry := AddonEntry{Git: fooDir}
+	downloadDir := t.TempDir()
+
+	done := make(chan error)
+	go func() {
+		done <- fetchGit(context.Background(), conf, &entry, downloadDir)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("checking out the submodules deadlocked")
+	}
+
+	toc := filepath.Join(downloadDir, entry.CloneSubdirName(), "Foo", "Libs", "Lib", "harness", "Foo", "Foo.toc")
+	if _, err := os.Stat(toc); err != nil {
+		t.Errorf("the submodule of the submodule was not checked out: %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
@@ -205,3 +206,67 @@ func GroupTOCFiles(tocs []TOCFile) ([]TOCFileGroup, error) {
 	}
 	return groups, nil
 }
+
+// TOCReferences lists the files a TOC file loads as slash separated paths, ex. Libs\LibStub\LibStub.lua -> Libs/LibStub/LibStub.lua.
+// Lines with [Family] style variables are skipped, what they load depends on the client.
+func TOCReferences(path string) ([]string, error) {
+	refs := []string{}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return refs, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(sanitizeTocLine(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "[") {
+			continue
+		}
+
+		refs = append(refs, strings.ReplaceAll(line, "\\", "/"))
+	}
+
+	return refs, scanner.Err()
+}
+
+// MissingTOCReferences returns the files the TOC files of an addon dir load that are not in it,
+// ex. libraries of a git submodule that wasn't checked out. WoW matches paths case insensitively.
+func MissingTOCReferences(dir string) ([]string, error) {
+	missing := []string{}
+
+	files := map[string]bool{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[strings.ToLower(filepath.ToSlash(rel))] = true
+		return nil
+	})
+	if err != nil {
+		return missing, err
+	}
+
+	tocs, err := filepath.Glob(filepath.Join(dir, "*.toc"))
+	if err != nil {
+		return missing, err
+	}
+	for _, toc := range tocs {
+		refs, err := TOCReferences(toc)
+		if err != nil {
+			return missing, err
+		}
+		for _, ref := range refs {
+			if !files[strings.ToLower(ref)] && !slices.Contains(missing, ref) {
+				missing = append(missing, ref)
+			}
+		}
+	}
+
+	return missing, nil
+}
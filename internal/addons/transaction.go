@@ -0,0 +1,508 @@
+package addons
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/RadiantRainbow/wow-addon-cli/internal/util"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/ksuid"
+)
+
+// staged and replaced dirs are dot prefixed siblings of the live addon dir
+// so they are on the same filesystem for renames, and ignored by cleaning
+const STAGE_INFIX = ".wac-stage-"
+const OLD_INFIX = ".wac-old-"
+
+// the journal records swaps so an interrupted run can be rolled back on the next run
+const TXN_JOURNAL = ".wow_addon_cli_txn.json"
+
+// TxnSwap is one change to the live AddOns dir.
+// Dest is the live addon dir, Old is where the previous version was moved (empty if there was none)
+// and Installed is true when a new version was moved into Dest. The marker beside a linked
+// previous version is moved along with it, beside Old.
+type TxnSwap struct {
+	Dest      string
+	Old       string
+	Installed bool
+}
+
+// txnJournal is what the journal records. Committed is set before previous versions are deleted,
+// from then on an interrupted run is finished instead of rolled back.
+type txnJournal struct {
+	Committed bool      `json:"committed,omitempty"`
+	Swaps     []TxnSwap `json:"swaps"`
+}
+
+// Transaction groups every change to AddOns for a run so they can be undone together
+type Transaction struct {
+	conf      Conf
+	id        string
+	committed bool
+	Swaps     []TxnSwap
+}
+
+func NewTransaction(conf Conf) *Transaction {
+	return &Transaction{
+		conf: conf,
+		id:   ksuid.New().String(),
+	}
+}
+
+func (txn *Transaction) journalPath() string {
+	return filepath.Join(txn.conf.AddonsPath, TXN_JOURNAL)
+}
+
+func (txn *Transaction) writeJournal() error {
+	data, err := json.MarshalIndent(txnJournal{Committed: txn.committed, Swaps: txn.Swaps}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(txn.journalPath(), data, 0644)
+}
+
+func (txn *Transaction) sideDir(addonName, infix string) string {
+	return filepath.Join(txn.conf.AddonsPath, fmt.Sprintf(".%s%s%s-%d", addonName, infix, txn.id, len(txn.Swaps)))
+}
+
+// Stage copies srcDir into a temp dir next to the live addon dir, marks it and validates the copy
+func (txn *Transaction) Stage(addonName, srcDir string, marker Marker) (string, error) {
+	stageDir := txn.sideDir(addonName, STAGE_INFIX)
+
+	log.Debug().Msgf("Staging %v to %v", srcDir, stageDir)
+	err := os.MkdirAll(stageDir, 0755)
+	if err != nil {
+		return stageDir, err
+	}
+
+	err = util.CopyDir(stageDir, srcDir)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return stageDir, err
+	}
+
+	log.Debug().Msgf("Creating marker file in %s", stageDir)
+	err = WriteMarker(stageDir, marker)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return stageDir, err
+	}
+
+	err = ValidateStagedDir(stageDir, srcDir)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return stageDir, fmt.Errorf("staged copy of %v is invalid: %w", addonName, err)
+	}
+
+	return stageDir, nil
+}
+
+// Link installs a symlink to an addon dir of a working copy instead of a copy of it.
+// The marker is written beside the link once it is swapped in, rolling back the swap removes it.
+func (txn *Transaction) Link(addonName, targetDir string, marker Marker) error {
+	tocs, err := filepath.Glob(filepath.Join(targetDir, "*.toc"))
+	if err != nil {
+		return err
+	}
+	if len(tocs) == 0 {
+		return fmt.Errorf("no toc file in %v", targetDir)
+	}
+
+	stageLink := txn.sideDir(addonName, STAGE_INFIX)
+	log.Debug().Msgf("Linking %v to %v", stageLink, targetDir)
+	err = os.Symlink(targetDir, stageLink)
+	if err != nil {
+		return err
+	}
+
+	err = txn.Install(addonName, stageLink)
+	if err != nil {
+		return err
+	}
+
+	marker.Link = targetDir
+	return WriteMarker(filepath.Join(txn.conf.AddonsPath, addonName), marker)
+}
+
+// Install swaps a staged dir into the live addon dir, moving the previous version aside
+func (txn *Transaction) Install(addonName, stageDir string) error {
+	dest := filepath.Join(txn.conf.AddonsPath, addonName)
+
+	swap, err := txn.newSwap(dest)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return err
+	}
+	swap.Installed = true
+
+	err = txn.journal(swap)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return err
+	}
+
+	err = moveAside(swap)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return err
+	}
+
+	log.Debug().Msgf("Swapping %v into %v", stageDir, dest)
+	err = os.Rename(stageDir, dest)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return err
+	}
+
+	return nil
+}
+
+// Remove moves a live addon dir aside, it is deleted on commit
+func (txn *Transaction) Remove(dir string) error {
+	swap, err := txn.newSwap(dir)
+	if err != nil || swap.Old == "" {
+		return err
+	}
+
+	err = txn.journal(swap)
+	if err != nil {
+		return err
+	}
+
+	return moveAside(swap)
+}
+
+// newSwap backs up the live dir, if there is one, and picks where it will be moved aside to
+func (txn *Transaction) newSwap(dest string) (TxnSwap, error) {
+	swap := TxnSwap{Dest: dest}
+
+	exists, err := util.FileExists(dest)
+	if err != nil || !exists {
+		return swap, err
+	}
+
+	err = BackupAddonDir(txn.conf, dest)
+	if err != nil {
+		return swap, err
+	}
+
+	swap.Old = txn.sideDir(filepath.Base(dest), OLD_INFIX)
+	return swap, nil
+}
+
+// journal records a swap before any of its renames, so a run interrupted halfway through one
+// is rolled back by the next run
+func (txn *Transaction) journal(swap TxnSwap) error {
+	txn.Swaps = append(txn.Swaps, swap)
+	err := txn.writeJournal()
+	if err != nil {
+		txn.Swaps = txn.Swaps[:len(txn.Swaps)-1]
+	}
+
+	return err
+}
+
+// moveAside moves the live dir to swap.Old, the marker beside a link first so the
+// new version's marker can't overwrite it
+func moveAside(swap TxnSwap) error {
+	if swap.Old == "" {
+		return nil
+	}
+
+	err := os.Rename(linkMarkerPath(swap.Dest), linkMarkerPath(swap.Old))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	log.Debug().Msgf("Moving %v aside to %v", swap.Dest, swap.Old)
+	return os.Rename(swap.Dest, swap.Old)
+}
+
+// Rollback undoes every swap in reverse order, putting previous versions back
+func (txn *Transaction) Rollback() error {
+	var firstErr error
+	for i := len(txn.Swaps) - 1; i >= 0; i-- {
+		err := rollbackSwap(txn.Swaps[i])
+		if err != nil {
+			log.Error().Err(err).Msgf("error rolling back %v", txn.Swaps[i].Dest)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if firstErr != nil {
+		// keep the journal so the next run can try again
+		return firstErr
+	}
+
+	txn.Swaps = nil
+	return os.RemoveAll(txn.journalPath())
+}
+
+func rollbackSwap(swap TxnSwap) error {
+	movedAside := false
+	if swap.Old != "" {
+		exists, err := util.FileExists(swap.Old)
+		if err != nil {
+			return err
+		}
+		movedAside = exists
+	}
+
+	// journaled before the previous version was moved aside, it is still live
+	if swap.Old != "" && !movedAside {
+		return restoreLinkMarker(swap)
+	}
+
+	if swap.Installed {
+		log.Debug().Msgf("Rolling back install of %v", swap.Dest)
+		err := os.RemoveAll(swap.Dest)
+		if err != nil {
+			return err
+		}
+		// the marker of an installed link
+		err = os.Remove(linkMarkerPath(swap.Dest))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	err := restoreLinkMarker(swap)
+	if err != nil {
+		return err
+	}
+
+	if movedAside {
+		log.Debug().Msgf("Restoring %v from %v", swap.Dest, swap.Old)
+		return os.Rename(swap.Old, swap.Dest)
+	}
+
+	return nil
+}
+
+// restoreLinkMarker puts back the marker of a linked previous version that was moved aside
+func restoreLinkMarker(swap TxnSwap) error {
+	if swap.Old == "" {
+		return nil
+	}
+
+	err := os.Rename(linkMarkerPath(swap.Old), linkMarkerPath(swap.Dest))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
+// Commit deletes the previous versions that were moved aside. It is journaled first, a half deleted
+// previous version must not be restored over the new one.
+func (txn *Transaction) Commit() error {
+	txn.committed = true
+	err := txn.writeJournal()
+	if err != nil {
+		return err
+	}
+
+	return txn.finishCommit()
+}
+
+func (txn *Transaction) finishCommit() error {
+	for _, swap := range txn.Swaps {
+		// the marker beside a link goes with it
+		if info, err := os.Lstat(swap.Dest); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			err = os.Remove(linkMarkerPath(swap.Dest))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		if swap.Old == "" {
+			continue
+		}
+		log.Debug().Msgf("Removing previous version %v", swap.Old)
+		err := os.RemoveAll(swap.Old)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(linkMarkerPath(swap.Old))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	txn.Swaps = nil
+	return os.RemoveAll(txn.journalPath())
+}
+
+// RecoverTransaction rolls back a run that was interrupted before committing, finishes one interrupted
+// while committing, and deletes leftover staging and moved aside dirs
+func RecoverTransaction(conf Conf) error {
+	txn := NewTransaction(conf)
+
+	data, err := os.ReadFile(txn.journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		journal := txnJournal{}
+		// journals of older versions are only the swaps
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(data, &journal.Swaps)
+		} else {
+			err = json.Unmarshal(data, &journal)
+		}
+		if err != nil {
+			return fmt.Errorf("error reading transaction journal %v: %w", txn.journalPath(), err)
+		}
+		txn.Swaps = journal.Swaps
+		txn.committed = journal.Committed
+
+		if txn.committed {
+			log.Warn().Msg("Found a run interrupted while committing, finishing it")
+			err = txn.finishCommit()
+		} else {
+			log.Warn().Msg("Found an unfinished run, rolling it back")
+			err = txn.Rollback()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = sweepOldDirs(conf)
+	if err != nil {
+		return err
+	}
+
+	stageDirs, err := filepath.Glob(filepath.Join(conf.AddonsPath, ".*"+STAGE_INFIX+"*"))
+	if err != nil {
+		return err
+	}
+	for _, d := range stageDirs {
+		log.Debug().Msgf("Removing leftover staging dir %v", d)
+		err = os.RemoveAll(d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sweepOldDirs handles moved aside dirs no journal knows about, ex. left by a crash while committing.
+// A dir whose addon is missing is put back, otherwise it is a previous version and deleted.
+func sweepOldDirs(conf Conf) error {
+	oldDirs, err := filepath.Glob(filepath.Join(conf.AddonsPath, ".*"+OLD_INFIX+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, old := range oldDirs {
+		// the marker of a moved aside link is handled with the link, or removed if the link is gone
+		if oldLink, ok := strings.CutSuffix(filepath.Base(old), MARKER); ok {
+			linked, err := util.FileExists(filepath.Join(conf.AddonsPath, strings.TrimPrefix(oldLink, ".")))
+			if err != nil {
+				return err
+			}
+			if !linked {
+				log.Debug().Msgf("Removing leftover marker %v", old)
+				err = os.Remove(old)
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+			}
+			continue
+		}
+
+		addonName, _, _ := strings.Cut(strings.TrimPrefix(filepath.Base(old), "."), OLD_INFIX)
+		dest := filepath.Join(conf.AddonsPath, addonName)
+		exists, err := util.FileExists(dest)
+		if err != nil {
+			return err
+		}
+
+		if !exists && addonName != "" {
+			log.Warn().Msgf("Restoring %v from leftover %v", addonName, old)
+			err = os.Rename(old, dest)
+			if err == nil {
+				err = restoreLinkMarker(TxnSwap{Dest: dest, Old: old})
+			}
+		} else {
+			log.Debug().Msgf("Removing leftover previous version %v", old)
+			err = os.RemoveAll(old)
+			if err == nil {
+				err = os.Remove(linkMarkerPath(old))
+			}
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateStagedDir checks a staged addon dir has a TOC file at its root
+// and contains every file CopyDir should have copied from srcDir
+func ValidateStagedDir(stageDir, srcDir string) error {
+	tocs, err := filepath.Glob(filepath.Join(stageDir, "*.toc"))
+	if err != nil {
+		return err
+	}
+	if len(tocs) == 0 {
+		return fmt.Errorf("no toc file in %v", stageDir)
+	}
+
+	srcDir, err = filepath.EvalSymlinks(srcDir)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path == srcDir {
+			return nil
+		}
+
+		// CopyDir skips dot files
+		if strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		srcInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		stagedPath := filepath.Join(stageDir, path[len(srcDir):])
+		stagedInfo, err := os.Stat(stagedPath)
+		if err != nil {
+			return fmt.Errorf("missing staged file: %w", err)
+		}
+
+		if stagedInfo.Size() != srcInfo.Size() {
+			return fmt.Errorf("staged file %v is %d bytes, expected %d", stagedPath, stagedInfo.Size(), srcInfo.Size())
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,261 @@
+package addons
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeAddon(t *testing.T, dir, content string) {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, filepath.Base(dir)+".toc"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readAddon(t *testing.T, dir string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.Base(dir)+".toc"))
+	if err != nil {
+		t.Fatalf("reading %v: %v", dir, err)
+	}
+	return string(data)
+}
+
+func TestRecoverTransaction(t *testing.T) {
+	addonsPath := t.TempDir()
+	conf := Conf{AddonsPath: addonsPath}
+	txn := NewTransaction(conf)
+
+	// crashed after journaling, before the previous version was moved aside
+	notMoved := filepath.Join(addonsPath, "NotMoved")
+	writeAddon(t, notMoved, "old")
+	// crashed after moving the previous version aside, before the new one was swapped in
+	moved := filepath.Join(addonsPath, "Moved")
+	writeAddon(t, moved, "old")
+	// crashed after the new version was swapped in
+	swapped := filepath.Join(addonsPath, "Swapped")
+	writeAddon(t, swapped, "old")
+
+	for _, dest := range []string{notMoved, moved, swapped} {
+		swap, err := txn.newSwap(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		swap.Installed = true
+		if err := txn.journal(swap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := moveAside(txn.Swaps[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := moveAside(txn.Swaps[2]); err != nil {
+		t.Fatal(err)
+	}
+	writeAddon(t, swapped, "new")
+
+	// moved aside by a run that left no journal
+	orphan := filepath.Join(addonsPath, ".Orphan"+OLD_INFIX+"x-0")
+	writeAddon(t, orphan, "old")
+	if err := os.Rename(filepath.Join(orphan, ".Orphan"+OLD_INFIX+"x-0.toc"), filepath.Join(orphan, "Orphan.toc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RecoverTransaction(conf); err != nil {
+		t.Fatalf("RecoverTransaction: %v", err)
+	}
+
+	for _, dest := range []string{notMoved, moved, swapped} {
+		if got := readAddon(t, dest); got != "old" {
+			t.Errorf("%v is %q after recovery, want old", filepath.Base(dest), got)
+		}
+	}
+	if got := readAddon(t, filepath.Join(addonsPath, "Orphan")); got != "old" {
+		t.Errorf("orphan was not restored, got %q", got)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(addonsPath, ".*"))
+	if len(leftovers) > 0 {
+		t.Errorf("leftovers after recovery: %v", leftovers)
+	}
+}
+
+func TestRecoverCommittedTransaction(t *testing.T) {
+	addonsPath := t.TempDir()
+	conf := Conf{AddonsPath: addonsPath}
+	txn := NewTransaction(conf)
+
+	dest := filepath.Join(addonsPath, "Foo")
+	writeAddon(t, dest, "old")
+	if err := os.WriteFile(filepath.Join(dest, "Foo.lua"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stage := filepath.Join(addonsPath, ".Foo"+STAGE_INFIX+"x")
+	writeAddon(t, stage, "new")
+	if err := os.Rename(filepath.Join(stage, filepath.Base(stage)+".toc"), filepath.Join(stage, "Foo.toc")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Install("Foo", stage); err != nil {
+		t.Fatal(err)
+	}
+
+	// crashed while deleting the previous version: the journal says committed, the old dir is half gone
+	txn.committed = true
+	if err := txn.writeJournal(); err != nil {
+		t.Fatal(err)
+	}
+	old := txn.Swaps[0].Old
+	if err := os.Remove(filepath.Join(old, "Foo.toc")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RecoverTransaction(conf); err != nil {
+		t.Fatalf("RecoverTransaction: %v", err)
+	}
+
+	if got := readAddon(t, dest); got != "new" {
+		t.Errorf("Foo is %q after recovery, want the committed new version", got)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(addonsPath, ".*"))
+	if len(leftovers) > 0 {
+		t.Errorf("leftovers after recovery: %v", leftovers)
+	}
+}
+
+func TestLinkTransaction(t *testing.T) {
+	tests := []struct {
+		name string
+		// previous is what Foo is before the run, "" for nothing, "link" or "dir"
+		previous string
+		// end is how the run ends, "rollback", "recover" for a crash before committing, or "commit"
+		end  string
+		want string
+	}{
+		{name: "fresh link rolled back", end: "rollback"},
+		{name: "fresh link recovered", end: "recover"},
+		{name: "fresh link committed", end: "commit", want: "new"},
+		{name: "replaced link rolled back", previous: "link", end: "rollback", want: "old"},
+		{name: "replaced link recovered", previous: "link", end: "recover", want: "old"},
+		{name: "replaced link committed", previous: "link", end: "commit", want: "new"},
+		{name: "replaced dir rolled back", previous: "dir", end: "rollback", want: "old"},
+		{name: "replaced dir committed", previous: "dir", end: "commit", want: "new"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addonsPath := t.TempDir()
+			conf := Conf{AddonsPath: addonsPath}
+			dest := filepath.Join(addonsPath, "Foo")
+
+			oldTarget := filepath.Join(t.TempDir(), "Foo")
+			writeAddon(t, oldTarget, "old")
+			newTarget := filepath.Join(t.TempDir(), "Foo")
+			writeAddon(t, newTarget, "new")
+
+			switch test.previous {
+			case "link":
+				txn := NewTransaction(conf)
+				if err := txn.Link("Foo", oldTarget, Marker{Source: "old"}); err != nil {
+					t.Fatal(err)
+				}
+				if err := txn.Commit(); err != nil {
+					t.Fatal(err)
+				}
+			case "dir":
+				writeAddon(t, dest, "old")
+				if err := WriteMarker(dest, Marker{Source: "old"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			txn := NewTransaction(conf)
+			if err := txn.Link("Foo", newTarget, Marker{Source: "new"}); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			switch test.end {
+			case "rollback":
+				err = txn.Rollback()
+			case "recover":
+				err = RecoverTransaction(conf)
+			case "commit":
+				err = txn.Commit()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			leftovers, _ := filepath.Glob(filepath.Join(addonsPath, ".*"))
+			if test.want == "" {
+				if _, err := os.Lstat(dest); !os.IsNotExist(err) {
+					t.Errorf("Foo should be gone: %v", err)
+				}
+				if len(leftovers) > 0 {
+					t.Errorf("leftovers: %v", leftovers)
+				}
+				return
+			}
+
+			if got := readAddon(t, dest); got != test.want {
+				t.Errorf("Foo is %q, want %v", got, test.want)
+			}
+			marker, err := ReadMarker(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if marker.Source != test.want {
+				t.Errorf("Foo has the marker of %q, want %v", marker.Source, test.want)
+			}
+
+			// only the marker beside a link is left
+			info, _ := os.Lstat(dest)
+			linked := info.Mode()&os.ModeSymlink != 0
+			if len(leftovers) > 1 || len(leftovers) == 1 && (!linked || leftovers[0] != linkMarkerPath(dest)) {
+				t.Errorf("leftovers: %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestRecoverMovedAsideLink(t *testing.T) {
+	addonsPath := t.TempDir()
+	conf := Conf{AddonsPath: addonsPath}
+	target := filepath.Join(t.TempDir(), "Foo")
+	writeAddon(t, target, "old")
+
+	// a link and its marker moved aside by a run that left no journal, and the marker of a link already gone
+	old := filepath.Join(addonsPath, ".Foo"+OLD_INFIX+"x-0")
+	if err := os.Symlink(target, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(linkMarkerPath(old), []byte(`{"source": "old"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gone := linkMarkerPath(filepath.Join(addonsPath, ".Bar"+OLD_INFIX+"x-1"))
+	if err := os.WriteFile(gone, []byte(`{"source": "old"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RecoverTransaction(conf); err != nil {
+		t.Fatalf("RecoverTransaction: %v", err)
+	}
+
+	dest := filepath.Join(addonsPath, "Foo")
+	if got := readAddon(t, dest); got != "old" {
+		t.Errorf("Foo is %q after recovery, want old", got)
+	}
+	marker, err := ReadMarker(dest)
+	if err != nil || marker.Source != "old" {
+		t.Errorf("the link's marker was not restored: %+v %v", marker, err)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(addonsPath, ".*"))
+	if len(leftovers) != 1 || leftovers[0] != linkMarkerPath(dest) {
+		t.Errorf("leftovers after recovery: %v", leftovers)
+	}
+}
@@ -0,0 +1,250 @@
+package addons
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+	ChannelAlpha  = "alpha"
+)
+
+// channels in the order they include each other, alpha takes betas and stable releases too
+var channelRank = map[string]int{
+	ChannelStable: 0,
+	ChannelBeta:   1,
+	ChannelAlpha:  2,
+}
+
+// ex. v2.4.1, r123, release-2.4, 2.4.1-beta2-classic
+var tagVersionRe = regexp.MustCompile(`(?i)^(?:v|r|ver|version|release)?[-_.]?(\d+(?:\.\d+)*)(.*)$`)
+
+// game flavor suffixes addon authors append to tags, they don't affect the version
+var flavorSuffixRe = regexp.MustCompile(`(?i)[-_.+](classic|classic_era|era|vanilla|bcc|tbc|wrath|wotlk|cata|mists|mop|retail|mainline|wow|nolib)$`)
+
+// pre-release suffixes, ex. beta2, rc1, b3 or alpha, a1
+var betaRe = regexp.MustCompile(`(?i)beta|rc|pre|^b\d*$`)
+var alphaRe = regexp.MustCompile(`(?i)alpha|dev|nightly|^a\d*$`)
+var digitsRe = regexp.MustCompile(`\d+`)
+
+// TagVersion is a version parsed from a git tag name
+type TagVersion struct {
+	Tag     string
+	Parts   []int
+	Channel string
+	// PreNum orders pre-releases of the same version, ex. 2 for beta2
+	PreNum int
+}
+
+// ParseTagVersion parses a tag like v2.4.1 or 2.4.1-beta2-classic, ok is false for tags that aren't versions
+func ParseTagVersion(tag string) (TagVersion, bool) {
+	m := tagVersionRe.FindStringSubmatch(tag)
+	if m == nil {
+		return TagVersion{}, false
+	}
+
+	v := TagVersion{
+		Tag:     tag,
+		Channel: ChannelStable,
+	}
+	for _, p := range strings.Split(m[1], ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return TagVersion{}, false
+		}
+		v.Parts = append(v.Parts, n)
+	}
+
+	suffix := m[2]
+	for flavorSuffixRe.MatchString(suffix) {
+		suffix = flavorSuffixRe.ReplaceAllString(suffix, "")
+	}
+	suffix = strings.Trim(suffix, "-_.+")
+
+	switch {
+	case suffix == "":
+	case alphaRe.MatchString(suffix):
+		v.Channel = ChannelAlpha
+	case betaRe.MatchString(suffix):
+		v.Channel = ChannelBeta
+	}
+
+	if v.Channel != ChannelStable {
+		digits := digitsRe.FindAllString(suffix, -1)
+		if len(digits) > 0 {
+			v.PreNum, _ = strconv.Atoi(digits[len(digits)-1])
+		}
+	}
+
+	return v, true
+}
+
+func compareParts(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		x, y := 0, 0
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// Compare orders versions, a stable release is newer than the pre-releases of the same version
+func (v TagVersion) Compare(o TagVersion) int {
+	if c := compareParts(v.Parts, o.Parts); c != 0 {
+		return c
+	}
+	if v.Channel != o.Channel {
+		if channelRank[v.Channel] > channelRank[o.Channel] {
+			return -1
+		}
+		return 1
+	}
+	if v.PreNum != o.PreNum {
+		if v.PreNum < o.PreNum {
+			return -1
+		}
+		return 1
+	}
+
+	return 0
+}
+
+type versionBound struct {
+	op    string
+	parts []int
+}
+
+// VersionConstraint is a set of bounds a version must all satisfy, ex. ^2.4 is >=2.4 <3
+type VersionConstraint []versionBound
+
+// ParseVersionConstraint parses constraints like ^2.4, ~2.4.1, 2.4 (any 2.4.x), =2.4.1, >=2.4 <3 and *.
+// Bounds are separated by spaces or commas.
+func ParseVersionConstraint(s string) (VersionConstraint, error) {
+	constraint := VersionConstraint{}
+
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if field == "*" || field == "x" {
+			continue
+		}
+
+		op := ""
+		for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(field, prefix) {
+				op = prefix
+				break
+			}
+		}
+
+		v, ok := ParseTagVersion(strings.TrimSpace(strings.TrimPrefix(field, op)))
+		if !ok {
+			return nil, fmt.Errorf("invalid version constraint %q", s)
+		}
+		parts := v.Parts
+
+		switch op {
+		case "^":
+			// the first non zero part may not change
+			upper := []int{}
+			for i, p := range parts {
+				if p != 0 || i == len(parts)-1 {
+					upper = append(upper, p+1)
+					break
+				}
+				upper = append(upper, 0)
+			}
+			constraint = append(constraint, versionBound{">=", parts}, versionBound{"<", upper})
+		case "~":
+			// patch updates, or minor updates when only the major is given
+			upper := []int{parts[0] + 1}
+			if len(parts) > 1 {
+				upper = []int{parts[0], parts[1] + 1}
+			}
+			constraint = append(constraint, versionBound{">=", parts}, versionBound{"<", upper})
+		case "":
+			// a partial version matches any version that starts with it
+			upper := append([]int{}, parts...)
+			upper[len(upper)-1]++
+			constraint = append(constraint, versionBound{">=", parts}, versionBound{"<", upper})
+		default:
+			constraint = append(constraint, versionBound{op, parts})
+		}
+	}
+
+	return constraint, nil
+}
+
+// Matches checks the numeric version against every bound, pre-releases are filtered by channel instead
+func (constraint VersionConstraint) Matches(v TagVersion) bool {
+	for _, bound := range constraint {
+		c := compareParts(v.Parts, bound.parts)
+		ok := false
+		switch bound.op {
+		case ">=":
+			ok = c >= 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case "<":
+			ok = c < 0
+		case "=":
+			ok = c == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selectTag picks the highest tag that satisfies the entry's version constraint and channel
+func selectTag(entry AddonEntry, tags []string) (string, error) {
+	constraint, err := ParseVersionConstraint(entry.Version)
+	if err != nil {
+		return "", err
+	}
+
+	channel := entry.Channel
+	if channel == "" {
+		channel = ChannelStable
+	}
+
+	candidates := []TagVersion{}
+	for _, tag := range tags {
+		v, ok := ParseTagVersion(tag)
+		if !ok {
+			continue
+		}
+		if channelRank[v.Channel] > channelRank[channel] || !constraint.Matches(v) {
+			continue
+		}
+		candidates = append(candidates, v)
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no tag of %v satisfies version %q in the %v channel", entry.Git, entry.Version, channel)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Compare(candidates[j]) > 0
+	})
+
+	return candidates[0].Tag, nil
+}
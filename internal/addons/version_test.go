@@ -0,0 +1,172 @@
+package addons
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTagVersion(t *testing.T) {
+	tests := []struct {
+		tag     string
+		ok      bool
+		parts   []int
+		channel string
+		preNum  int
+	}{
+		{tag: "2.4.1", ok: true, parts: []int{2, 4, 1}, channel: ChannelStable},
+		{tag: "v2.4.1", ok: true, parts: []int{2, 4, 1}, channel: ChannelStable},
+		{tag: "V10.2", ok: true, parts: []int{10, 2}, channel: ChannelStable},
+		{tag: "r123", ok: true, parts: []int{123}, channel: ChannelStable},
+		{tag: "release-2.4", ok: true, parts: []int{2, 4}, channel: ChannelStable},
+		{tag: "version_3.0", ok: true, parts: []int{3, 0}, channel: ChannelStable},
+		{tag: "2.4.1-classic", ok: true, parts: []int{2, 4, 1}, channel: ChannelStable},
+		{tag: "2.4.1-wotlk-nolib", ok: true, parts: []int{2, 4, 1}, channel: ChannelStable},
+		{tag: "2.4.1-beta2-classic", ok: true, parts: []int{2, 4, 1}, channel: ChannelBeta, preNum: 2},
+		{tag: "v1.0.0-rc.3", ok: true, parts: []int{1, 0, 0}, channel: ChannelBeta, preNum: 3},
+		{tag: "1.0.0-b4", ok: true, parts: []int{1, 0, 0}, channel: ChannelBeta, preNum: 4},
+		{tag: "1.0.0-alpha", ok: true, parts: []int{1, 0, 0}, channel: ChannelAlpha},
+		{tag: "1.0.0-a7", ok: true, parts: []int{1, 0, 0}, channel: ChannelAlpha, preNum: 7},
+		{tag: "1.0.0-nightly", ok: true, parts: []int{1, 0, 0}, channel: ChannelAlpha},
+		{tag: "latest"},
+		{tag: "main"},
+		{tag: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			v, ok := ParseTagVersion(test.tag)
+			if ok != test.ok {
+				t.Fatalf("got ok %v, want %v", ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+			if !slices.Equal(v.Parts, test.parts) || v.Channel != test.channel || v.PreNum != test.preNum {
+				t.Errorf("got %v %v %v, want %v %v %v", v.Parts, v.Channel, v.PreNum, test.parts, test.channel, test.preNum)
+			}
+		})
+	}
+}
+
+func TestTagVersionCompare(t *testing.T) {
+	// oldest first
+	ordered := []string{"v1.9", "2.0.0-alpha1", "2.0.0-alpha2", "2.0.0-beta1", "v2.0.0-beta2", "2.0.0", "2.0.1-classic", "2.1", "v10.0"}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := ParseTagVersion(ordered[i])
+			b, _ := ParseTagVersion(ordered[j])
+			want := 0
+			if i < j {
+				want = -1
+			} else if i > j {
+				want = 1
+			}
+			if got := a.Compare(b); got != want {
+				t.Errorf("%v compared to %v is %v, want %v", ordered[i], ordered[j], got, want)
+			}
+		}
+	}
+
+	a, _ := ParseTagVersion("2.0")
+	b, _ := ParseTagVersion("v2.0.0")
+	if a.Compare(b) != 0 {
+		t.Errorf("2.0 and v2.0.0 should be equal")
+	}
+}
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+		err        bool
+	}{
+		{constraint: "", matches: []string{"0.1", "2.4.1", "10"}},
+		{constraint: "*", matches: []string{"0.1", "2.4.1"}},
+		{constraint: "^2.4", matches: []string{"2.4", "2.4.9", "2.9"}, rejects: []string{"2.3.9", "3.0", "1.9"}},
+		{constraint: "^0.2.3", matches: []string{"0.2.3", "0.2.9"}, rejects: []string{"0.3.0", "0.2.2"}},
+		{constraint: "^0", matches: []string{"0.0.1", "0.9"}, rejects: []string{"1.0"}},
+		{constraint: "~2.4.1", matches: []string{"2.4.1", "2.4.9"}, rejects: []string{"2.5", "2.4.0"}},
+		{constraint: "~2", matches: []string{"2.0", "2.9.9"}, rejects: []string{"3.0", "1.9"}},
+		{constraint: "2.4", matches: []string{"2.4", "2.4.7"}, rejects: []string{"2.5", "2.3.9"}},
+		{constraint: "v2", matches: []string{"2.0", "2.9"}, rejects: []string{"3.0"}},
+		{constraint: "=2.4.1", matches: []string{"2.4.1", "v2.4.1.0"}, rejects: []string{"2.4.2", "2.4"}},
+		{constraint: ">=2.4 <3", matches: []string{"2.4", "2.99"}, rejects: []string{"2.3", "3.0"}},
+		{constraint: ">2.4,<=3", matches: []string{"2.4.1", "3.0"}, rejects: []string{"2.4", "3.0.1"}},
+		{constraint: "^abc", err: true},
+		{constraint: ">=", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.constraint, func(t *testing.T) {
+			constraint, err := ParseVersionConstraint(test.constraint)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", constraint)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tag := range test.matches {
+				v, _ := ParseTagVersion(tag)
+				if !constraint.Matches(v) {
+					t.Errorf("%v should match %v", test.constraint, tag)
+				}
+			}
+			for _, tag := range test.rejects {
+				v, _ := ParseTagVersion(tag)
+				if constraint.Matches(v) {
+					t.Errorf("%v should not match %v", test.constraint, tag)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectTag(t *testing.T) {
+	tags := []string{"v1.0.0", "v1.1.0", "v1.2.0-beta1", "v2.0.0-alpha1", "v1.1.1-classic", "latest", "v2.0.0-beta2", "v0.9"}
+
+	tests := []struct {
+		name    string
+		version string
+		channel string
+		want    string
+		err     string
+	}{
+		{name: "newest stable", want: "v1.1.1-classic"},
+		{name: "beta channel", channel: ChannelBeta, want: "v2.0.0-beta2"},
+		{name: "alpha channel takes betas", channel: ChannelAlpha, want: "v2.0.0-beta2"},
+		{name: "caret", version: "^1", want: "v1.1.1-classic"},
+		{name: "caret beta", version: "^1", channel: ChannelBeta, want: "v1.2.0-beta1"},
+		{name: "tilde", version: "~1.0", want: "v1.0.0"},
+		{name: "range", version: ">=0.5 <1.1", want: "v1.0.0"},
+		{name: "exact", version: "=0.9", want: "v0.9"},
+		{name: "no match", version: "^3", err: `no tag of https://example.com/Addon.git satisfies version "^3" in the stable channel`},
+		{name: "no stable match", version: "^2", err: "in the stable channel"},
+		{name: "bad constraint", version: "^x", err: "invalid version constraint"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry := AddonEntry{Git: "https://example.com/Addon.git", Version: test.version, Channel: test.channel}
+			got, err := selectTag(entry, tags)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got %v error %v, want %q", got, err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
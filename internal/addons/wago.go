@@ -0,0 +1,107 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const DefaultWagoAPI = "https://addons.wago.io/api/external"
+
+// the game_version names of the Wago API by flavor
+var WAGO_GAME_VERSIONS = map[string]string{
+	FlavorRetail:  "retail",
+	FlavorVanilla: "classic",
+	FlavorTBC:     "bc",
+	FlavorWrath:   "wotlk",
+	FlavorCata:    "cata",
+	FlavorMists:   "mop",
+}
+
+type wagoRelease struct {
+	Label        string    `json:"label"`
+	CreatedAt    time.Time `json:"created_at"`
+	DownloadLink string    `json:"download_link"`
+}
+
+type wagoAddon struct {
+	ID            string                  `json:"id"`
+	DisplayName   string                  `json:"display_name"`
+	RecentRelease map[string]*wagoRelease `json:"recent_release"`
+}
+
+func (c Conf) wagoAPI() string {
+	if c.WagoAPI != "" {
+		return strings.TrimSuffix(c.WagoAPI, "/")
+	}
+
+	return DefaultWagoAPI
+}
+
+// wagoAuth is the API key header, from the config or WAGO_API_KEY. It is only sent to the API's server.
+func (c Conf) wagoAuth(server string) map[string]string {
+	key := c.WagoKey
+	if key == "" {
+		key = os.Getenv("WAGO_API_KEY")
+	}
+	if key == "" || !sameServer(server, c.wagoAPI()) {
+		return map[string]string{}
+	}
+
+	return map[string]string{"Authorization": "Bearer " + key}
+}
+
+// listWagoReleases returns the most recent release of each channel of the entry's addon for the entry's flavor
+func listWagoReleases(ctx context.Context, conf Conf, entry AddonEntry) ([]Release, error) {
+	auth := conf.wagoAuth(conf.wagoAPI())
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("%v needs a Wago API key, set wagokey in the config or WAGO_API_KEY", entry.SourceKey())
+	}
+
+	flavor, err := conf.entryFlavor(entry)
+	if err != nil {
+		return nil, err
+	}
+	apiURL := fmt.Sprintf("%s/addons/%s", conf.wagoAPI(), url.PathEscape(entry.Wago))
+	if flavor != "" {
+		apiURL += "?game_version=" + WAGO_GAME_VERSIONS[flavor]
+	}
+
+	addon := wagoAddon{}
+	err = getJSON(ctx, apiURL, auth, &addon)
+	if err != nil {
+		return nil, err
+	}
+
+	name := addon.DisplayName
+	if name == "" {
+		name = entry.Wago
+	}
+
+	releases := []Release{}
+	for _, channel := range []string{ChannelStable, ChannelBeta, ChannelAlpha} {
+		wr := addon.RecentRelease[channel]
+		if wr == nil || wr.DownloadLink == "" {
+			continue
+		}
+
+		asset := ReleaseAsset{
+			Name: name + "-" + wr.Label + ".zip",
+			URL:  wr.DownloadLink,
+		}
+		if flavor != "" {
+			asset.Flavors = []string{flavor}
+		}
+		releases = append(releases, Release{
+			Tag:       wr.Label,
+			Channel:   channel,
+			Published: wr.CreatedAt,
+			Assets:    []ReleaseAsset{asset},
+		})
+	}
+
+	return releases, nil
+}
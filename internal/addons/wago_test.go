@@ -0,0 +1,139 @@
+package addons
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const wagoAddonJSON = `{"id": "aR7m2ZKb", "display_name": "Addon",
+  "recent_release": {
+    "stable": {"label": "2.0.0", "created_at": "2024-02-01T00:00:00Z", "download_link": "%[1]s/dl/stable"},
+    "beta": {"label": "2.1.0-beta1", "created_at": "2024-03-01T00:00:00Z", "download_link": "%[1]s/dl/beta"},
+    "alpha": null
+  }}`
+
+// wagoServer stands in for the Wago API, it records the Authorization header and game_version of each request
+func wagoServer(t *testing.T) (*httptest.Server, *[]string, *[]string) {
+	t.Helper()
+	auth := []string{}
+	gameVersions := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		gameVersions = append(gameVersions, r.URL.Query().Get("game_version"))
+		if r.URL.Path != "/addons/aR7m2ZKb" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(strings.ReplaceAll(wagoAddonJSON, "%[1]s", server.URL)))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &auth, &gameVersions
+}
+
+func TestListWagoReleases(t *testing.T) {
+	t.Setenv("WAGO_API_KEY", "")
+	server, auth, gameVersions := wagoServer(t)
+	conf := Conf{WagoAPI: server.URL + "/", WagoKey: "secret"}
+	entry := AddonEntry{Wago: "aR7m2ZKb"}
+
+	releases, err := listWagoReleases(context.Background(), conf, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 2 || releases[0].Channel != ChannelStable || releases[1].Channel != ChannelBeta {
+		t.Fatalf("got releases %+v, want a stable and a beta", releases)
+	}
+	asset := releases[1].Assets[0]
+	if asset.Name != "Addon-2.1.0-beta1.zip" || asset.URL != server.URL+"/dl/beta" || len(asset.Flavors) != 0 {
+		t.Errorf("got asset %+v", asset)
+	}
+	if (*auth)[0] != "Bearer secret" {
+		t.Errorf("got Authorization %q, want the key", (*auth)[0])
+	}
+	if (*gameVersions)[0] != "" {
+		t.Errorf("sent game_version %q without a flavor", (*gameVersions)[0])
+	}
+}
+
+func TestListWagoReleasesFlavor(t *testing.T) {
+	t.Setenv("WAGO_API_KEY", "secret")
+	server, _, gameVersions := wagoServer(t)
+
+	for flavor, gameVersion := range WAGO_GAME_VERSIONS {
+		t.Run(flavor, func(t *testing.T) {
+			*gameVersions = nil
+			conf := Conf{WagoAPI: server.URL}
+			releases, err := listWagoReleases(context.Background(), conf, AddonEntry{Wago: "aR7m2ZKb", Flavor: flavor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (*gameVersions)[0] != gameVersion {
+				t.Errorf("got game_version %q, want %q", (*gameVersions)[0], gameVersion)
+			}
+			if got := releases[0].Assets[0].Flavors; len(got) != 1 || got[0] != flavor {
+				t.Errorf("got asset flavors %v, want %v", got, flavor)
+			}
+		})
+	}
+
+	// the config's flavor applies when the entry has none
+	*gameVersions = nil
+	conf := Conf{WagoAPI: server.URL, Flavor: "wotlk"}
+	if _, err := listWagoReleases(context.Background(), conf, AddonEntry{Wago: "aR7m2ZKb"}); err != nil {
+		t.Fatal(err)
+	}
+	if (*gameVersions)[0] != "wotlk" {
+		t.Errorf("got game_version %q for the config flavor, want wotlk", (*gameVersions)[0])
+	}
+}
+
+func TestListWagoReleasesNoKey(t *testing.T) {
+	t.Setenv("WAGO_API_KEY", "")
+	server, auth, _ := wagoServer(t)
+	conf := Conf{WagoAPI: server.URL}
+
+	_, err := listWagoReleases(context.Background(), conf, AddonEntry{Wago: "aR7m2ZKb"})
+	if err == nil || !strings.Contains(err.Error(), "needs a Wago API key") {
+		t.Fatalf("got error %v, want the missing key error", err)
+	}
+	if len(*auth) != 0 {
+		t.Errorf("made %v requests without a key", len(*auth))
+	}
+}
+
+func TestWagoAuth(t *testing.T) {
+	t.Setenv("WAGO_API_KEY", "")
+	conf := Conf{WagoAPI: "https://wago.example.com/api/external", WagoKey: "secret"}
+	entry := AddonEntry{Wago: "aR7m2ZKb"}
+
+	tests := []struct {
+		name     string
+		assetURL string
+		auth     string
+	}{
+		{name: "download from the API's server", assetURL: "https://wago.example.com/api/external/addons/aR7m2ZKb/download", auth: "Bearer secret"},
+		{name: "download from a CDN", assetURL: "https://cdn.example.com/Addon.zip"},
+		{name: "download over http", assetURL: "http://wago.example.com/Addon.zip"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := conf.releaseAuth(entry, test.assetURL)
+			if headers["Authorization"] != test.auth {
+				t.Errorf("got headers %v, want Authorization %q", headers, test.auth)
+			}
+		})
+	}
+
+	t.Setenv("WAGO_API_KEY", "env")
+	headers := Conf{}.wagoAuth(DefaultWagoAPI)
+	if headers["Authorization"] != "Bearer env" {
+		t.Errorf("WAGO_API_KEY not used, got %v", headers)
+	}
+}
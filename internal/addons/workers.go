@@ -0,0 +1,64 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const DefaultWorkers = 4
+const DefaultHostWorkers = 2
+
+// fetchActions runs resolveAction for every action on a bounded pool of workers, with at most
+// HostWorkers fetching from the same host at once. Errors are returned in action order.
+func fetchActions(ctx context.Context, conf Conf, installed map[string]*Marker, actions []Action) []error {
+	workers := conf.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	hostWorkers := conf.HostWorkers
+	if hostWorkers <= 0 {
+		hostWorkers = DefaultHostWorkers
+	}
+
+	hostSems := map[string]chan struct{}{}
+	for _, action := range actions {
+		host := action.Entry.SourceHost()
+		if _, ok := hostSems[host]; !ok {
+			hostSems[host] = make(chan struct{}, hostWorkers)
+		}
+	}
+
+	errs := make([]error, len(actions))
+	jobs := make(chan int)
+
+	wg := sync.WaitGroup{}
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sem := hostSems[actions[i].Entry.SourceHost()]
+				sem <- struct{}{}
+				errs[i] = resolveAction(ctx, conf, installed, &actions[i])
+				<-sem
+			}
+		}()
+	}
+
+	for i := range actions {
+		// stop handing out entries once interrupted, in flight fetches are cancelled by ctx
+		if ctx.Err() != nil {
+			for j := i; j < len(actions); j++ {
+				errs[j] = fmt.Errorf("interrupted: %w", ctx.Err())
+			}
+			break
+		}
+
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errs
+}
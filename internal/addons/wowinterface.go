@@ -0,0 +1,78 @@
+package addons
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const DefaultWoWInterfaceAPI = "https://api.mmoui.com/v4/game/WOW"
+
+type wowinterfaceFile struct {
+	UID             string `json:"UID"`
+	UIName          string `json:"UIName"`
+	UIVersion       string `json:"UIVersion"`
+	UIDate          int64  `json:"UIDate"`
+	UIDownload      string `json:"UIDownload"`
+	UIFileName      string `json:"UIFileName"`
+	UICompatibility []struct {
+		Version string `json:"version"`
+		Name    string `json:"name"`
+	} `json:"UICompatibility"`
+}
+
+func (c Conf) wowinterfaceAPI() string {
+	if c.WoWInterfaceAPI != "" {
+		return strings.TrimSuffix(c.WoWInterfaceAPI, "/")
+	}
+
+	return DefaultWoWInterfaceAPI
+}
+
+// listWoWInterfaceReleases returns the current file of the entry's addon id, the API only has the latest one.
+// Its flavors are the game versions it is marked compatible with.
+func listWoWInterfaceReleases(ctx context.Context, conf Conf, entry AddonEntry) ([]Release, error) {
+	headers := map[string]string{}
+	key := conf.WoWInterfaceKey
+	if key == "" {
+		key = os.Getenv("WOWINTERFACE_API_KEY")
+	}
+	if key != "" {
+		headers["X-API-Token"] = key
+	}
+
+	files := []wowinterfaceFile{}
+	apiURL := fmt.Sprintf("%s/filedetails/%s.json", conf.wowinterfaceAPI(), entry.WoWInterface)
+	err := getJSON(ctx, apiURL, headers, &files)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []Release{}
+	for _, file := range files {
+		asset := ReleaseAsset{
+			Name: file.UIFileName,
+			URL:  file.UIDownload,
+		}
+		if asset.Name == "" {
+			asset.Name = file.UIName + "-" + file.UIVersion + ".zip"
+		}
+		for _, compat := range file.UICompatibility {
+			flavor := interfaceFlavor(compat.Version)
+			if flavor != "" && !slices.Contains(asset.Flavors, flavor) {
+				asset.Flavors = append(asset.Flavors, flavor)
+			}
+		}
+
+		releases = append(releases, Release{
+			Tag:       file.UIVersion,
+			Published: time.UnixMilli(file.UIDate),
+			Assets:    []ReleaseAsset{asset},
+		})
+	}
+
+	return releases, nil
+}
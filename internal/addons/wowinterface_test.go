@@ -0,0 +1,120 @@
+package addons
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const wowinterfaceFilesJSON = `[
+  {"UID": "12345", "UIName": "Addon", "UIVersion": "2.0.0", "UIDate": 1706745600000,
+   "UIDownload": "%[1]s/dl/12345", "UIFileName": "%[2]s",
+   "UICompatibility": [{"version": "3.4.3", "name": "Wrath of the Lich King Classic"}, {"version": "1.15.0", "name": "Classic"}, {"version": "3.4.2", "name": "Wrath of the Lich King Classic"}]}
+]`
+
+// wowinterfaceServer stands in for the WoWInterface API, it records the X-API-Token header of each request
+func wowinterfaceServer(t *testing.T, fileName string) (*httptest.Server, *[]string) {
+	t.Helper()
+	tokens := []string{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens = append(tokens, r.Header.Get("X-API-Token"))
+		if r.URL.Path != "/filedetails/12345.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		files := strings.ReplaceAll(wowinterfaceFilesJSON, "%[1]s", server.URL)
+		w.Write([]byte(strings.ReplaceAll(files, "%[2]s", fileName)))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &tokens
+}
+
+func TestListWoWInterfaceReleases(t *testing.T) {
+	t.Setenv("WOWINTERFACE_API_KEY", "")
+	server, tokens := wowinterfaceServer(t, "Addon-2.0.0.zip")
+	conf := Conf{WoWInterfaceAPI: server.URL + "/"}
+	entry := AddonEntry{WoWInterface: "12345"}
+
+	releases, err := listWoWInterfaceReleases(context.Background(), conf, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 || releases[0].Tag != "2.0.0" || releases[0].Published.Year() != 2024 {
+		t.Fatalf("got releases %+v", releases)
+	}
+	asset := releases[0].Assets[0]
+	if asset.Name != "Addon-2.0.0.zip" || asset.URL != server.URL+"/dl/12345" {
+		t.Errorf("got asset %+v", asset)
+	}
+	if strings.Join(asset.Flavors, " ") != "wrath vanilla" {
+		t.Errorf("got flavors %v, want each compatible flavor once", asset.Flavors)
+	}
+	if (*tokens)[0] != "" {
+		t.Errorf("sent X-API-Token %q without a key", (*tokens)[0])
+	}
+
+	t.Setenv("WOWINTERFACE_API_KEY", "secret")
+	if _, err := listWoWInterfaceReleases(context.Background(), conf, entry); err != nil {
+		t.Fatal(err)
+	}
+	if (*tokens)[1] != "secret" {
+		t.Errorf("got X-API-Token %q, want WOWINTERFACE_API_KEY", (*tokens)[1])
+	}
+}
+
+func TestListWoWInterfaceReleasesNoFileName(t *testing.T) {
+	server, _ := wowinterfaceServer(t, "")
+	conf := Conf{WoWInterfaceAPI: server.URL}
+
+	releases, err := listWoWInterfaceReleases(context.Background(), conf, AddonEntry{WoWInterface: "12345"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := releases[0].Assets[0].Name; got != "Addon-2.0.0.zip" {
+		t.Errorf("got asset name %v, want it made from the addon name and version", got)
+	}
+}
+
+func TestResolveWoWInterfaceRelease(t *testing.T) {
+	server, _ := wowinterfaceServer(t, "Addon-2.0.0.zip")
+
+	tests := []struct {
+		name   string
+		entry  AddonEntry
+		flavor string
+		err    string
+	}{
+		{name: "no flavor"},
+		{name: "compatible flavor", entry: AddonEntry{Flavor: FlavorWrath}},
+		{name: "compatible config flavor", flavor: "classic"},
+		{name: "incompatible flavor", entry: AddonEntry{Flavor: FlavorRetail}, err: "is for retail"},
+		{name: "incompatible config flavor", flavor: "cata", err: "is for cata"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := Conf{WoWInterfaceAPI: server.URL, Flavor: test.flavor}
+			entry := test.entry
+			entry.WoWInterface = "12345"
+
+			err := resolveRelease(context.Background(), conf, &entry)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Zip != server.URL+"/dl/12345" {
+				t.Errorf("got zip %v", entry.Zip)
+			}
+		})
+	}
+}
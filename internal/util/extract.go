@@ -0,0 +1,291 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bodgit/sevenzip"
+	"github.com/rs/zerolog/log"
+	"github.com/ulikunitz/xz"
+)
+
+type ArchiveFormat string
+
+const (
+	FormatZip      ArchiveFormat = "zip"
+	FormatTar      ArchiveFormat = "tar"
+	FormatTarGz    ArchiveFormat = "tar.gz"
+	FormatTarBz2   ArchiveFormat = "tar.bz2"
+	FormatTarXz    ArchiveFormat = "tar.xz"
+	FormatSevenZip ArchiveFormat = "7z"
+)
+
+// archive file name suffixes, longest first so .tar.gz is not taken for .gz
+var archiveSuffixes = []struct {
+	suffix string
+	format ArchiveFormat
+}{
+	{".tar.gz", FormatTarGz},
+	{".tar.bz2", FormatTarBz2},
+	{".tar.xz", FormatTarXz},
+	{".tgz", FormatTarGz},
+	{".tbz2", FormatTarBz2},
+	{".txz", FormatTarXz},
+	{".tar", FormatTar},
+	{".zip", FormatZip},
+	{".7z", FormatSevenZip},
+}
+
+var archiveContentTypes = map[string]ArchiveFormat{
+	"application/zip":              FormatZip,
+	"application/x-zip-compressed": FormatZip,
+	"application/x-tar":            FormatTar,
+	"application/gzip":             FormatTarGz,
+	"application/x-gzip":           FormatTarGz,
+	"application/x-gtar":           FormatTarGz,
+	"application/x-bzip2":          FormatTarBz2,
+	"application/x-xz":             FormatTarXz,
+	"application/x-7z-compressed":  FormatSevenZip,
+}
+
+// ArchiveFormatFromName detects an archive format by file name, ex. Bagnon-1.2.tar.gz
+func ArchiveFormatFromName(name string) ArchiveFormat {
+	lower := strings.ToLower(name)
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return s.format
+		}
+	}
+
+	return ""
+}
+
+// TrimArchiveExt removes an archive suffix from a file name, ex. Bagnon-1.2.tar.gz -> Bagnon-1.2
+func TrimArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, s := range archiveSuffixes {
+		if strings.HasSuffix(lower, s.suffix) {
+			return name[:len(name)-len(s.suffix)]
+		}
+	}
+
+	return RemoveExt(name)
+}
+
+// ArchiveFormatFromContentType detects an archive format by a Content-Type header, ex. application/gzip
+func ArchiveFormatFromContentType(contentType string) ArchiveFormat {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+
+	return archiveContentTypes[mediaType]
+}
+
+// ArchiveFormatFromMagic detects an archive format by the first bytes of its contents
+func ArchiveFormatFromMagic(header []byte) ArchiveFormat {
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return FormatTarGz
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return FormatTarBz2
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return FormatTarXz
+	case bytes.HasPrefix(header, []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}):
+		return FormatSevenZip
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return FormatTar
+	}
+
+	return ""
+}
+
+// DetectArchiveFormat detects the format of the archive at path by its magic bytes, falling back
+// to hints in order, each a Content-Type or a file name, ex. from the url or Content-Disposition
+func DetectArchiveFormat(path string, hints ...string) (ArchiveFormat, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(fp, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+
+	format := ArchiveFormatFromMagic(header[:n])
+	for _, hint := range hints {
+		if format != "" {
+			break
+		}
+		format = ArchiveFormatFromContentType(hint)
+		if format == "" {
+			format = ArchiveFormatFromName(hint)
+		}
+	}
+
+	if format == "" {
+		return "", fmt.Errorf("%v is not a zip, tar.gz, tar.bz2, tar.xz or 7z archive", path)
+	}
+
+	return format, nil
+}
+
+// Extract unpacks the archive at path into destDir
+func Extract(path string, format ArchiveFormat, destDir string) error {
+	switch format {
+	case FormatZip:
+		return Unzip(path, destDir)
+	case FormatSevenZip:
+		return extractSevenZip(path, destDir)
+	}
+
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	var r io.Reader = fp
+	switch format {
+	case FormatTar:
+	case FormatTarGz:
+		gz, err := gzip.NewReader(fp)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case FormatTarBz2:
+		r = bzip2.NewReader(fp)
+	case FormatTarXz:
+		r, err = xz.NewReader(fp)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported archive format %q", format)
+	}
+
+	return untar(r, destDir)
+}
+
+// ArchivePath joins the name of an archive member or git tree entry to destDir, refusing names that escape it
+func ArchivePath(destDir string, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(name, "/")))
+	if clean == "." {
+		return destDir, nil
+	}
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("archive member %q is outside the archive", name)
+	}
+
+	return filepath.Join(destDir, clean), nil
+}
+
+func untar(r io.Reader, destDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		filePath, err := ArchivePath(destDir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(filePath, 0755)
+		case tar.TypeReg:
+			err = writeArchiveFile(filePath, tr, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			// like zips, links are not installed, a link could let later members write outside destDir
+			log.Debug().Msgf("Skipping symlink %s -> %s", header.Name, header.Linkname)
+			continue
+		default:
+			// pax headers are handled by the reader, hard links and devices are not installed
+			log.Debug().Msgf("Skipping %s of type %c", header.Name, header.Typeflag)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Debug().Msgf("Extracted: %s", filePath)
+	}
+}
+
+func extractSevenZip(path string, destDir string) error {
+	r, err := sevenzip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		filePath, err := ArchivePath(destDir, f.Name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			err = os.MkdirAll(filePath, 0755)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeArchiveFile(filePath, rc, f.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		log.Debug().Msgf("Extracted: %s", filePath)
+	}
+
+	return nil
+}
+
+func writeArchiveFile(filePath string, r io.Reader, mode os.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	// only the executable bit is kept
+	perm := os.FileMode(0644)
+	if mode&0111 != 0 {
+		perm = 0755
+	}
+
+	outFile, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	_, err = io.Copy(outFile, r)
+	return err
+}
@@ -0,0 +1,203 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarHeader is the first block of a tar archive, it has the ustar magic at offset 257
+func tarHeader() []byte {
+	header := make([]byte, 512)
+	copy(header[257:], "ustar")
+	return header
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		hints   []string
+		want    ArchiveFormat
+		err     bool
+	}{
+		{name: "zip magic", content: []byte("PK\x03\x04rest"), want: FormatZip},
+		{name: "empty zip magic", content: []byte("PK\x05\x06rest"), want: FormatZip},
+		{name: "gzip magic", content: []byte{0x1f, 0x8b, 0x08}, want: FormatTarGz},
+		{name: "bzip2 magic", content: []byte("BZh91AY"), want: FormatTarBz2},
+		{name: "xz magic", content: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, want: FormatTarXz},
+		{name: "7z magic", content: []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c, 0x00}, want: FormatSevenZip},
+		{name: "tar magic", content: tarHeader(), want: FormatTar},
+		{name: "magic over hints", content: []byte("PK\x03\x04"), hints: []string{"application/gzip", "Addon.tar.gz"}, want: FormatZip},
+		{name: "content type", content: []byte("????"), hints: []string{"application/x-7z-compressed"}, want: FormatSevenZip},
+		{name: "content type with parameters", content: []byte("????"), hints: []string{"application/zip; charset=binary"}, want: FormatZip},
+		{name: "file name", content: []byte("????"), hints: []string{"Addon-1.2.TGZ"}, want: FormatTarGz},
+		{name: "tar.bz2 is not taken for tar", content: []byte("????"), hints: []string{"Addon-1.2.tar.bz2"}, want: FormatTarBz2},
+		{name: "first hint that matches", content: []byte("????"), hints: []string{"application/octet-stream", "", "Addon.tar.xz", "Addon.zip"}, want: FormatTarXz},
+		{name: "empty file", hints: []string{"Addon.zip"}, want: FormatZip},
+		{name: "unknown", content: []byte("<html>"), hints: []string{"text/html", "download"}, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "download")
+			if err := os.WriteFile(path, test.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := DetectArchiveFormat(path, test.hints...)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestTrimArchiveExt(t *testing.T) {
+	tests := map[string]string{
+		"Bagnon-1.2.tar.gz": "Bagnon-1.2",
+		"Bagnon-1.2.ZIP":    "Bagnon-1.2",
+		"Bagnon-1.2.7z":     "Bagnon-1.2",
+		"Bagnon-1.2.txt":    "Bagnon-1.2",
+		"Bagnon":            "Bagnon",
+	}
+
+	for name, want := range tests {
+		if got := TrimArchiveExt(name); got != want {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestArchivePath(t *testing.T) {
+	destDir := filepath.Join("dest", "dir")
+
+	tests := []struct {
+		name string
+		want string
+		err  bool
+	}{
+		{name: "Addon/Addon.toc", want: filepath.Join(destDir, "Addon", "Addon.toc")},
+		{name: "Addon/", want: filepath.Join(destDir, "Addon")},
+		{name: "./Addon/../Other/Other.toc", want: filepath.Join(destDir, "Other", "Other.toc")},
+		{name: "/Addon/Addon.toc", want: filepath.Join(destDir, "Addon", "Addon.toc")},
+		{name: ".", want: destDir},
+		{name: "", want: destDir},
+		{name: "../Addon.toc", err: true},
+		{name: "Addon/../../Addon.toc", err: true},
+		{name: "..", err: true},
+		{name: "/../etc/passwd", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ArchivePath(destDir, test.name)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// writeTarGz writes a tar.gz with the given headers, regular files get their name as content
+func writeTarGz(t *testing.T, path string, headers ...tar.Header) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if err := tw.WriteHeader(&header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(header.Name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTarGz(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "Addon.tar.gz")
+	writeTarGz(t, archive,
+		tar.Header{Name: "Addon/", Typeflag: tar.TypeDir, Mode: 0755},
+		tar.Header{Name: "Addon/Addon.toc", Typeflag: tar.TypeReg, Mode: 0600},
+		tar.Header{Name: "Addon/run.sh", Typeflag: tar.TypeReg, Mode: 0700},
+		tar.Header{Name: "Addon/link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	)
+
+	destDir := filepath.Join(dir, "out")
+	if err := Extract(archive, FormatTarGz, destDir); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(destDir, "Addon", "Addon.toc"))
+	if err != nil || string(content) != "Addon/Addon.toc" {
+		t.Fatalf("got %q %v", content, err)
+	}
+	for name, perm := range map[string]os.FileMode{"Addon.toc": 0644, "run.sh": 0755} {
+		info, err := os.Stat(filepath.Join(destDir, "Addon", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.Mode().Perm(); got != perm {
+			t.Errorf("%v has mode %v, want %v", name, got, perm)
+		}
+	}
+	if _, err := os.Lstat(filepath.Join(destDir, "Addon", "link")); !os.IsNotExist(err) {
+		t.Errorf("symlink should be skipped, got %v", err)
+	}
+}
+
+func TestExtractRefusesEscapingMembers(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "Addon.tar.gz")
+	writeTarGz(t, archive,
+		tar.Header{Name: "Addon/Addon.toc", Typeflag: tar.TypeReg, Mode: 0644},
+		tar.Header{Name: "../evil.lua", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+
+	destDir := filepath.Join(dir, "out")
+	err := Extract(archive, FormatTarGz, destDir)
+	if err == nil || !strings.Contains(err.Error(), "outside the archive") {
+		t.Fatalf("got error %v, want the member refused", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.lua")); !os.IsNotExist(err) {
+		t.Errorf("escaping member was written: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// HashFile returns the hex sha256 of a file's contents
+func HashFile(path string) (string, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer fp.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, fp)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// HashDir returns the hex sha256 of every regular file in dir keyed by its slash separated
+// path relative to dir. Files named in skip are left out.
+func HashDir(dir string, skip ...string) (map[string]string, error) {
+	hashes := map[string]string{}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		for _, s := range skip {
+			if rel == s {
+				return nil
+			}
+		}
+
+		hashes[rel], err = HashFile(path)
+		return err
+	})
+
+	return hashes, err
+}
@@ -17,7 +17,10 @@ func Unzip(zipFilePath, destDir string) error {
 	defer r.Close()
 
 	for _, f := range r.File {
-		filePath := filepath.Join(destDir, f.Name)
+		filePath, err := ArchivePath(destDir, f.Name)
+		if err != nil {
+			return err
+		}
 
 		if f.FileInfo().IsDir() {
 			os.MkdirAll(filePath, os.ModePerm)
@@ -2,13 +2,15 @@ package util
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// FileExists is true if anything is at filePath, a symlink exists even if its target is gone
 func FileExists(filePath string) (bool, error) {
-	_, err := os.Stat(filePath)
+	_, err := os.Lstat(filePath)
 	if err == nil {
 		return true, nil // File exists
 	}
@@ -30,3 +32,30 @@ func IsDirectory(path string) (bool, error) {
 func RemoveExt(path string) string {
 	return strings.TrimSuffix(path, filepath.Ext(path))
 }
+
+// InsideDir checks that path is inside root without passing through a symlink, so removing or moving it
+// cannot reach files outside root. path itself may be a symlink, removing it only removes the link.
+func InsideDir(root string, path string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || !filepath.IsLocal(rel) {
+		return fmt.Errorf("%v is outside %v", path, root)
+	}
+
+	parent := root
+	parts := strings.Split(rel, string(filepath.Separator))
+	for _, part := range parts[:len(parts)-1] {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%v is under the symlink %v", path, parent)
+		}
+	}
+
+	return nil
+}
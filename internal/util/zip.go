@@ -0,0 +1,77 @@
+package util
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ZipDir writes the directory srcDir into a new zip archive at zipFilePath.
+// Entries are stored relative to the parent of srcDir so the archive contains
+// the directory itself, ex. Bagnon/Bagnon.toc. Unlike CopyDir, dot files are kept.
+func ZipDir(srcDir, zipFilePath string) error {
+	err := os.MkdirAll(filepath.Dir(zipFilePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	fp, err := os.Create(zipFilePath)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	w := zip.NewWriter(fp)
+	base := filepath.Dir(srcDir)
+
+	err = filepath.Walk(srcDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		// zip entries always use forward slashes
+		header.Name = filepath.ToSlash(rel)
+
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = w.CreateHeader(header)
+			return err
+		}
+
+		// symlinks are not followed, they are skipped
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header.Method = zip.Deflate
+		dst, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(dst, src)
+		return err
+	})
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
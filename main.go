@@ -15,10 +15,16 @@ import (
 func main() {
 	flagConfig := flag.String("config", "config.toml", "config file")
 	flagDownloadPath := flag.String("dlpath", ".downloads", "download path")
-	flagBackupPath := flag.String("backuppath", ".backups", "download path")
+	flagBackupPath := flag.String("backuppath", ".backups", "backup path")
 	flagAddonsPath := flag.String("addonspath", ".", "path to AddOns")
-	flagNoPreclean := flag.Bool("nopreclean", true, "skip cleaning non Blizzard addons before fetching")
+	flagNoPreclean := flag.Bool("nopreclean", true, "only replace addons whose source changed, instead of downloading and replacing every configured addon")
 	flagDebug := flag.Bool("debug", false, "sets log level to debug")
+	flagDryRun := flag.Bool("dry-run", false, "print the plan without changing AddOns")
+	flagFrozen := flag.Bool("frozen", false, "install exactly the versions in the lockfile, fail on mismatch")
+	flagCachePath := flag.String("cachepath", defaultCachePath(), "persistent download cache path, empty disables the cache")
+	flagOffline := flag.Bool("offline", false, "install only from the download cache")
+	flagWorkers := flag.Int("workers", 0, "number of concurrent fetches, overrides the workers config key (default 4)")
+	flag.Usage = usage
 	flag.Parse()
 
 	timeFormat := time.Kitchen
@@ -40,42 +46,77 @@ func main() {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
 	}
 
-	confData, err := os.ReadFile(*flagConfig)
-	if err != nil {
-		log.Fatal().Err(err)
+	// no command runs install, as before subcommands existed
+	cmdName := flag.Arg(0)
+	args := flag.Args()
+	if cmdName == "" {
+		cmdName = "install"
+	} else {
+		args = args[1:]
+	}
+
+	cmd := findCommand(cmdName)
+	if cmd == nil {
+		usage()
+		log.Fatal().Msgf("Unknown command %q", cmdName)
 	}
 
 	var conf addons.Conf
+	confData, err := os.ReadFile(*flagConfig)
+	if err != nil && (cmd.needsConfig || !os.IsNotExist(err)) {
+		log.Fatal().Err(err).Msg("Could not read config")
+	}
+
 	_, err = toml.Decode(string(confData), &conf)
 	if err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("Could not parse config")
+	}
+	conf.EntryKeys, err = addons.ConfigEntryKeys(string(confData))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not parse config")
 	}
 
+	configPath, err := filepath.Abs(*flagConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid config path")
+	}
+	conf.LockPath = addons.LockPathForConfig(configPath)
+	conf.Frozen = *flagFrozen
+
 	conf.AddonsPath, err = filepath.Abs(*flagAddonsPath)
 	if err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("Invalid addons path")
 	}
 
 	basenameAddonsPath := filepath.Base(conf.AddonsPath)
 	if !(basenameAddonsPath == "AddOns" || basenameAddonsPath == "Addons") {
-		log.Fatal().Err(err).Msgf("Addons path %v does not look like an addons path. Expecting 'AddOns' or 'Addons'", conf.AddonsPath)
-
+		log.Fatal().Msgf("Addons path %v does not look like an addons path. Expecting 'AddOns' or 'Addons'", conf.AddonsPath)
 	}
 
 	// change directories to AddOns so relative default paths work
 	err = os.Chdir(conf.AddonsPath)
 	if err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("Could not change to addons path")
 	}
 
 	conf.BackupPath, err = filepath.Abs(*flagBackupPath)
 	if err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("Invalid backup path")
 	}
 	conf.DownloadPath, err = filepath.Abs(*flagDownloadPath)
 	if err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("Invalid download path")
+	}
+	if conf.CachePath == "" || isFlagSet("cachepath") {
+		conf.CachePath = *flagCachePath
+	}
+	if conf.CachePath != "" {
+		conf.CachePath, err = filepath.Abs(conf.CachePath)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid cache path")
+		}
 	}
+	conf.Offline = *flagOffline
 
 	preCleanBliz := true
 	if *flagNoPreclean {
@@ -83,9 +124,48 @@ func main() {
 	}
 	conf.PrecleanBliz = preCleanBliz
 
-	log.Info().Msgf("Running with conf: %+v", conf)
-	err = addons.Execute(conf)
+	if *flagWorkers > 0 {
+		conf.Workers = *flagWorkers
+	}
+	dryRun = *flagDryRun
+
+	// entries with only a name are looked up in the catalogs, and urls without a .git or archive
+	// extension are probed once, before any command hydrates entries
+	if cmd.needsConfig {
+		err = conf.ResolveCatalogs()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not resolve config")
+		}
+		err = conf.ResolveSources()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not resolve config")
+		}
+	}
+
+	log.Debug().Msgf("Running %v with conf: %+v", cmd.name, conf)
+	err = cmd.run(conf, args)
 	if err != nil {
-		log.Fatal().Err(err)
+		log.Fatal().Err(err).Msg("Run failed")
 	}
 }
+
+// defaultCachePath shares the download cache between AddOns dirs and configs
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".cache"
+	}
+
+	return filepath.Join(dir, "wow-addon-cli")
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}